@@ -0,0 +1,73 @@
+// Command pogocache runs the pogocache server: a shared in-memory
+// keyspace exposed over three protocol frontends (Redis/RESP,
+// Memcached, and PostgreSQL wire protocol) that all bridge pub/sub
+// through the same broker.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+
+	"github.com/tidwall/pogocache/internal/memcache"
+	"github.com/tidwall/pogocache/internal/postgres"
+	"github.com/tidwall/pogocache/internal/pubsub"
+	"github.com/tidwall/pogocache/internal/resp"
+	"github.com/tidwall/pogocache/internal/store"
+	"github.com/tidwall/pogocache/internal/tracking"
+)
+
+// maxTrackedKeysPerClient bounds each RESP3 client's tracked-key table
+// before CLIENT TRACKING falls back to flushing the whole table.
+const maxTrackedKeysPerClient = 1000
+
+func main() {
+	dir := flag.String("dir", "", "data directory (unused by the in-memory engine, accepted for compatibility)")
+	port := flag.Int("port", 9401, "RESP (Redis) frontend port")
+	memcachePort := flag.Int("memcache-port", 0, "Memcached frontend port (0 disables it)")
+	postgresPort := flag.Int("postgres-port", 0, "PostgreSQL wire-protocol frontend port (0 disables it)")
+	flag.Parse()
+
+	if *dir != "" {
+		if err := os.MkdirAll(*dir, 0o755); err != nil {
+			log.Fatalf("pogocache: creating data directory: %v", err)
+		}
+	}
+
+	st := store.New()
+	broker := pubsub.NewBroker()
+	trk := tracking.New(maxTrackedKeysPerClient)
+
+	listenAndServe("resp", *port, func(ln net.Listener) error {
+		return resp.NewServer(st, broker, trk).Serve(ln)
+	})
+	if *memcachePort != 0 {
+		listenAndServe("memcache", *memcachePort, func(ln net.Listener) error {
+			return memcache.NewServer(st).Serve(ln)
+		})
+	}
+	if *postgresPort != 0 {
+		listenAndServe("postgres", *postgresPort, func(ln net.Listener) error {
+			return postgres.NewServer(st, broker).Serve(ln)
+		})
+	}
+
+	select {}
+}
+
+// listenAndServe binds addr and runs serve in a background goroutine,
+// fatally exiting the process if either the bind or the serve loop
+// fails.
+func listenAndServe(name string, port int, serve func(net.Listener) error) {
+	ln, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		log.Fatalf("pogocache: %s frontend: %v", name, err)
+	}
+	go func() {
+		if err := serve(ln); err != nil {
+			log.Fatalf("pogocache: %s frontend: %v", name, err)
+		}
+	}()
+}
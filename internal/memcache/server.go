@@ -0,0 +1,409 @@
+// Package memcache implements the Memcached frontend: the classic text
+// protocol (get/set/delete/incr/decr) and the meta protocol (mg/ms/md/ma
+// /me/mn), sharing the same keyspace as the other frontends.
+package memcache
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/pogocache/internal/store"
+)
+
+// Server serves the Memcached protocol over a net.Listener.
+type Server struct {
+	Store *store.Store
+}
+
+// NewServer wires up a Server sharing the given keyspace.
+func NewServer(st *store.Store) *Server {
+	return &Server{Store: st}
+}
+
+// Serve accepts connections from ln until it returns an error.
+func (s *Server) Serve(ln net.Listener) error {
+	for {
+		nc, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go s.serveConn(nc)
+	}
+}
+
+func (s *Server) serveConn(nc net.Conn) {
+	defer nc.Close()
+	r := bufio.NewReader(nc)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			continue
+		}
+		fields := strings.Fields(line)
+		switch fields[0] {
+		case "set":
+			s.handleSet(nc, r, fields)
+		case "get", "gets":
+			s.handleGet(nc, fields[1:])
+		case "delete":
+			s.handleDelete(nc, fields)
+		case "incr", "decr":
+			s.handleIncrDecr(nc, fields)
+		case "mg":
+			s.handleMG(nc, fields)
+		case "ms":
+			s.handleMS(nc, r, fields)
+		case "md":
+			s.handleMD(nc, fields)
+		case "ma":
+			s.handleMA(nc, fields)
+		case "me":
+			s.handleME(nc, fields)
+		case "mn":
+			fmt.Fprint(nc, "MN\r\n")
+		case "version":
+			fmt.Fprint(nc, "VERSION pogocache\r\n")
+		case "quit":
+			return
+		default:
+			fmt.Fprint(nc, "ERROR\r\n")
+		}
+	}
+}
+
+// expireAtToTTL converts a classic-protocol exptime into a TTL duration:
+// 0 means no expiry, values up to 30 days are relative seconds, and
+// anything larger is a Unix timestamp.
+func expireAtToTTL(exptime int) time.Duration {
+	const thirtyDays = 60 * 60 * 24 * 30
+	if exptime == 0 {
+		return 0
+	}
+	if exptime <= thirtyDays {
+		return time.Duration(exptime) * time.Second
+	}
+	return time.Until(time.Unix(int64(exptime), 0))
+}
+
+func hasFlag(fields []string, noreply string) bool {
+	for _, f := range fields {
+		if f == noreply {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) handleSet(nc net.Conn, r *bufio.Reader, fields []string) {
+	if len(fields) < 5 {
+		fmt.Fprint(nc, "ERROR\r\n")
+		return
+	}
+	key := fields[1]
+	flags, _ := strconv.ParseUint(fields[2], 10, 32)
+	exptime, _ := strconv.Atoi(fields[3])
+	n, _ := strconv.Atoi(fields[4])
+	data := make([]byte, n+2)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return
+	}
+	s.Store.Set(key, data[:n], uint32(flags), expireAtToTTL(exptime))
+	if !hasFlag(fields[5:], "noreply") {
+		fmt.Fprint(nc, "STORED\r\n")
+	}
+}
+
+func (s *Server) handleGet(nc net.Conn, keys []string) {
+	for _, key := range keys {
+		e, ok := s.Store.Get(key)
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(nc, "VALUE %s %d %d\r\n", key, e.Flags, len(e.Value))
+		nc.Write(e.Value)
+		fmt.Fprint(nc, "\r\n")
+	}
+	fmt.Fprint(nc, "END\r\n")
+}
+
+func (s *Server) handleDelete(nc net.Conn, fields []string) {
+	if len(fields) < 2 {
+		fmt.Fprint(nc, "ERROR\r\n")
+		return
+	}
+	ok := s.Store.Delete(fields[1])
+	if hasFlag(fields[2:], "noreply") {
+		return
+	}
+	if ok {
+		fmt.Fprint(nc, "DELETED\r\n")
+	} else {
+		fmt.Fprint(nc, "NOT_FOUND\r\n")
+	}
+}
+
+func (s *Server) handleIncrDecr(nc net.Conn, fields []string) {
+	if len(fields) < 3 {
+		fmt.Fprint(nc, "ERROR\r\n")
+		return
+	}
+	delta, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		fmt.Fprint(nc, "CLIENT_ERROR invalid numeric delta argument\r\n")
+		return
+	}
+	if fields[0] == "decr" {
+		delta = -delta
+	}
+	n, err := s.Store.Incr(fields[1], delta, false, 0, 0)
+	switch {
+	case err == store.ErrNotFound:
+		fmt.Fprint(nc, "NOT_FOUND\r\n")
+	case err != nil:
+		fmt.Fprint(nc, "CLIENT_ERROR cannot increment or decrement non-numeric value\r\n")
+	default:
+		fmt.Fprintf(nc, "%d\r\n", n)
+	}
+}
+
+// metaFlags maps each one-letter meta protocol flag to its token value
+// (empty for flags that carry none, like v or q).
+type metaFlags map[byte]string
+
+func parseMetaFlags(tokens []string) metaFlags {
+	m := make(metaFlags, len(tokens))
+	for _, tok := range tokens {
+		if tok == "" {
+			continue
+		}
+		m[tok[0]] = tok[1:]
+	}
+	return m
+}
+
+// responseFlags echoes back the flags a meta command response should
+// carry: opaque (O), client flags (f), CAS (c), hit-before-this-access
+// (h), and seconds-since-last-access (l), each only if the request
+// asked for it. e is the entry's snapshot from just before whatever
+// operation produced this response (store.Store.GetAndTouch's return
+// value for a read, or the fresh entry for a write), so h/l reflect
+// state prior to the access being reported rather than the access
+// itself.
+func responseFlags(req metaFlags, e store.Entry) string {
+	var b strings.Builder
+	if v, ok := req['O']; ok {
+		fmt.Fprintf(&b, " O%s", v)
+	}
+	if _, ok := req['f']; ok {
+		fmt.Fprintf(&b, " F%d", e.Flags)
+	}
+	if _, ok := req['c']; ok {
+		fmt.Fprintf(&b, " c%d", e.Cas)
+	}
+	if _, ok := req['h']; ok {
+		hit := 0
+		if e.Hit {
+			hit = 1
+		}
+		fmt.Fprintf(&b, " h%d", hit)
+	}
+	if _, ok := req['l']; ok {
+		var secs int64
+		if !e.LastAccess.IsZero() {
+			secs = int64(time.Since(e.LastAccess).Seconds())
+		}
+		fmt.Fprintf(&b, " l%d", secs)
+	}
+	return b.String()
+}
+
+// decodeMetaKey returns key as given, or base64-decoded if req carries
+// the b flag (the meta protocol's base64-encoded-key marker).
+func decodeMetaKey(key string, req metaFlags) (string, error) {
+	if _, ok := req['b']; !ok {
+		return key, nil
+	}
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return "", fmt.Errorf("bad base64 key %q", key)
+	}
+	return string(decoded), nil
+}
+
+func (s *Server) handleMG(nc net.Conn, fields []string) {
+	if len(fields) < 2 {
+		fmt.Fprint(nc, "CLIENT_ERROR bad command line\r\n")
+		return
+	}
+	flags := parseMetaFlags(fields[2:])
+	key, err := decodeMetaKey(fields[1], flags)
+	if err != nil {
+		fmt.Fprintf(nc, "CLIENT_ERROR %v\r\n", err)
+		return
+	}
+	e, ok := s.Store.GetAndTouch(key)
+	if !ok {
+		fmt.Fprint(nc, "EN\r\n")
+		return
+	}
+	if _, quiet := flags['q']; quiet {
+		return
+	}
+	resp := responseFlags(flags, e)
+	if _, wantValue := flags['v']; wantValue {
+		fmt.Fprintf(nc, "VA %d%s\r\n", len(e.Value), resp)
+		nc.Write(e.Value)
+		fmt.Fprint(nc, "\r\n")
+	} else {
+		fmt.Fprintf(nc, "HD%s\r\n", resp)
+	}
+}
+
+func (s *Server) handleMS(nc net.Conn, r *bufio.Reader, fields []string) {
+	if len(fields) < 3 {
+		fmt.Fprint(nc, "CLIENT_ERROR bad command line\r\n")
+		return
+	}
+	n, err := strconv.Atoi(fields[2])
+	if err != nil {
+		fmt.Fprint(nc, "CLIENT_ERROR bad data length\r\n")
+		return
+	}
+	data := make([]byte, n+2)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return
+	}
+	value := data[:n]
+	flags := parseMetaFlags(fields[3:])
+	key, err := decodeMetaKey(fields[1], flags)
+	if err != nil {
+		fmt.Fprintf(nc, "CLIENT_ERROR %v\r\n", err)
+		return
+	}
+
+	var clientFlags uint32
+	if v, ok := flags['F']; ok {
+		f, _ := strconv.ParseUint(v, 10, 32)
+		clientFlags = uint32(f)
+	}
+	var ttl time.Duration
+	if v, ok := flags['T']; ok {
+		secs, _ := strconv.Atoi(v)
+		ttl = expireAtToTTL(secs)
+	}
+	var newCas uint64
+	if v, ok := flags['E']; ok {
+		newCas, _ = strconv.ParseUint(v, 10, 64)
+	}
+
+	var entry store.Entry
+	if v, ok := flags['C']; ok {
+		cas, _ := strconv.ParseUint(v, 10, 64)
+		entry, err = s.Store.SetCASWithCas(key, value, clientFlags, ttl, cas, newCas)
+	} else {
+		entry = s.Store.SetWithCas(key, value, clientFlags, ttl, newCas)
+	}
+	switch {
+	case err == store.ErrCASMismatch:
+		fmt.Fprint(nc, "EX\r\n")
+	case err == store.ErrNotFound:
+		fmt.Fprint(nc, "NF\r\n")
+	default:
+		fmt.Fprintf(nc, "HD%s\r\n", responseFlags(flags, entry))
+	}
+}
+
+func (s *Server) handleMD(nc net.Conn, fields []string) {
+	if len(fields) < 2 {
+		fmt.Fprint(nc, "CLIENT_ERROR bad command line\r\n")
+		return
+	}
+	flags := parseMetaFlags(fields[2:])
+	key, err := decodeMetaKey(fields[1], flags)
+	if err != nil {
+		fmt.Fprintf(nc, "CLIENT_ERROR %v\r\n", err)
+		return
+	}
+	if s.Store.Delete(key) {
+		fmt.Fprint(nc, "HD\r\n")
+	} else {
+		fmt.Fprint(nc, "NF\r\n")
+	}
+}
+
+func (s *Server) handleMA(nc net.Conn, fields []string) {
+	if len(fields) < 2 {
+		fmt.Fprint(nc, "CLIENT_ERROR bad command line\r\n")
+		return
+	}
+	flags := parseMetaFlags(fields[2:])
+	key, err := decodeMetaKey(fields[1], flags)
+	if err != nil {
+		fmt.Fprintf(nc, "CLIENT_ERROR %v\r\n", err)
+		return
+	}
+
+	delta := int64(1)
+	if v, ok := flags['D']; ok {
+		delta, _ = strconv.ParseInt(v, 10, 64)
+	}
+	if flags['M'] == "D" {
+		delta = -delta
+	}
+
+	var autoViv bool
+	var ttl time.Duration
+	if v, ok := flags['N']; ok {
+		autoViv = true
+		secs, _ := strconv.Atoi(v)
+		ttl = expireAtToTTL(secs)
+	}
+	var initial int64
+	if v, ok := flags['J']; ok {
+		initial, _ = strconv.ParseInt(v, 10, 64)
+	}
+
+	n, err := s.Store.Incr(key, delta, autoViv, initial, ttl)
+	switch {
+	case err == store.ErrNotFound:
+		fmt.Fprint(nc, "NF\r\n")
+	case err != nil:
+		fmt.Fprint(nc, "CLIENT_ERROR cannot increment or decrement non-numeric value\r\n")
+	default:
+		if _, wantValue := flags['v']; wantValue {
+			val := strconv.FormatInt(n, 10)
+			fmt.Fprintf(nc, "VA %d\r\n%s\r\n", len(val), val)
+		} else {
+			fmt.Fprint(nc, "HD\r\n")
+		}
+	}
+}
+
+func (s *Server) handleME(nc net.Conn, fields []string) {
+	if len(fields) < 2 {
+		fmt.Fprint(nc, "CLIENT_ERROR bad command line\r\n")
+		return
+	}
+	flags := parseMetaFlags(fields[2:])
+	key, err := decodeMetaKey(fields[1], flags)
+	if err != nil {
+		fmt.Fprintf(nc, "CLIENT_ERROR %v\r\n", err)
+		return
+	}
+	e, ok := s.Store.Get(key)
+	if !ok {
+		fmt.Fprint(nc, "EN\r\n")
+		return
+	}
+	fmt.Fprintf(nc, "ME %s c%d s%d\r\n", fields[1], e.Cas, len(e.Value))
+}
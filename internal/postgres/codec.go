@@ -0,0 +1,56 @@
+package postgres
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// pgEpoch is the reference instant PostgreSQL's binary timestamptz
+// format counts microseconds from.
+var pgEpoch = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// decodeParam turns a Bind parameter's raw bytes into a Go value
+// (string, int64, or time.Time) according to its declared type OID and
+// wire format (0 = text, 1 = binary).
+func decodeParam(oid uint32, format int16, raw []byte) (any, error) {
+	switch oid {
+	case oidInt8:
+		if format == 1 {
+			if len(raw) != 8 {
+				return nil, fmt.Errorf("postgres: int8 binary parameter must be 8 bytes, got %d", len(raw))
+			}
+			return int64(binary.BigEndian.Uint64(raw)), nil
+		}
+		return strconv.ParseInt(string(raw), 10, 64)
+	case oidTimestamptz:
+		if format == 1 {
+			if len(raw) != 8 {
+				return nil, fmt.Errorf("postgres: timestamptz binary parameter must be 8 bytes, got %d", len(raw))
+			}
+			micros := int64(binary.BigEndian.Uint64(raw))
+			return pgEpoch.Add(time.Duration(micros) * time.Microsecond), nil
+		}
+		return time.Parse(time.RFC3339Nano, string(raw))
+	default: // text, bytea, and anything else travel as raw bytes
+		return string(raw), nil
+	}
+}
+
+// encodeResult encodes a result column value (string or int64) in the
+// requested wire format for inclusion in a DataRow.
+func encodeResult(oid uint32, format int16, val any) []byte {
+	switch oid {
+	case oidInt8:
+		n := val.(int64)
+		if format == 1 {
+			var b [8]byte
+			binary.BigEndian.PutUint64(b[:], uint64(n))
+			return b[:]
+		}
+		return []byte(strconv.FormatInt(n, 10))
+	default: // text: binary and text encodings are identical UTF-8 bytes
+		return []byte(val.(string))
+	}
+}
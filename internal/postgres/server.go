@@ -0,0 +1,679 @@
+// Package postgres implements the PostgreSQL wire-protocol frontend:
+// startup/auth, the simple query protocol (used for LISTEN/UNLISTEN/
+// NOTIFY), and the full extended query protocol (Parse/Bind/Describe/
+// Execute/Sync/Close) mapping a fixed set of pogo_* functions/
+// procedures onto the shared KV engine. It shares its keyspace and
+// pub/sub broker with the other frontends, which is what lets a NOTIFY
+// reach a Redis SUBSCRIBE client and vice versa.
+package postgres
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tidwall/pogocache/internal/pubsub"
+	"github.com/tidwall/pogocache/internal/store"
+)
+
+const (
+	sslRequestCode    = 80877103
+	cancelRequestCode = 80877102
+)
+
+// Server serves the PostgreSQL wire protocol over a net.Listener.
+type Server struct {
+	Store  *store.Store
+	Broker *pubsub.Broker
+
+	nextID atomic.Uint64
+}
+
+// NewServer wires up a Server sharing the given keyspace and pub/sub
+// broker with other frontends.
+func NewServer(st *store.Store, broker *pubsub.Broker) *Server {
+	return &Server{Store: st, Broker: broker}
+}
+
+// Serve accepts connections from ln until it returns an error.
+func (s *Server) Serve(ln net.Listener) error {
+	for {
+		nc, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go s.serveConn(nc)
+	}
+}
+
+type scanCursor struct {
+	keys []string
+	pos  int
+}
+
+type portalState struct {
+	stmt          *preparedStmt
+	params        []any
+	resultFormats []int16
+	cursor        *scanCursor
+}
+
+type conn struct {
+	server *Server
+	id     uint64
+	nc     net.Conn
+	bw     *bufio.Writer
+	wmu    sync.Mutex
+	sub    *pubsub.Subscriber
+
+	stmts   map[string]*preparedStmt
+	portals map[string]*portalState
+}
+
+func (s *Server) serveConn(nc net.Conn) {
+	defer nc.Close()
+	// A malformed message (e.g. a parameter whose declared OID doesn't
+	// match the Go type decodeParam actually produced) can make a type
+	// assertion elsewhere in this goroutine panic. Recovering here keeps
+	// that confined to this one connection instead of taking down every
+	// other connection across all three frontends.
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("postgres: recovered from panic, closing connection: %v", r)
+		}
+	}()
+	r := bufio.NewReader(nc)
+
+	if !handshake(r, nc) {
+		return
+	}
+
+	c := &conn{
+		server:  s,
+		id:      s.nextID.Add(1),
+		nc:      nc,
+		bw:      bufio.NewWriter(nc),
+		stmts:   make(map[string]*preparedStmt),
+		portals: make(map[string]*portalState),
+	}
+	c.sub = pubsub.NewSubscriber(c.id)
+	defer s.Broker.UnsubscribeAll(c.sub)
+
+	go c.pumpNotifications()
+
+	c.sendReady()
+	for {
+		typ, payload, err := readMessage(r)
+		if err != nil {
+			return
+		}
+		switch typ {
+		case 'Q':
+			q, _ := readCString(payload)
+			c.handleSimpleQuery(q)
+		case 'P':
+			c.handleParse(payload)
+		case 'B':
+			c.handleBind(payload)
+		case 'D':
+			c.handleDescribe(payload)
+		case 'E':
+			c.handleExecute(payload)
+		case 'S':
+			c.sendReady()
+		case 'C':
+			c.handleClose(payload)
+		case 'H':
+			c.flush()
+		case 'X':
+			return
+		default:
+			// Unrecognized message type; ignore and keep reading.
+		}
+	}
+}
+
+// handshake negotiates (trivially) past SSLRequest/CancelRequest and
+// the real StartupMessage, then completes authentication. It returns
+// false if the connection should be abandoned.
+func handshake(r *bufio.Reader, nc net.Conn) bool {
+	for {
+		payload, err := readStartupPacket(r)
+		if err != nil {
+			return false
+		}
+		if len(payload) < 4 {
+			return false
+		}
+		code, _ := getInt32(payload)
+		switch uint32(code) {
+		case sslRequestCode:
+			if _, err := nc.Write([]byte{'N'}); err != nil {
+				return false
+			}
+			continue
+		case cancelRequestCode:
+			return false
+		default:
+			// A real StartupMessage (protocol version 3.0, then
+			// key/value parameters we don't need to inspect).
+			w := bufio.NewWriter(nc)
+			writeMessage(w, 'R', []byte{0, 0, 0, 0}) // AuthenticationOk
+			writeParameterStatus(w, "server_version", "14.0")
+			writeParameterStatus(w, "client_encoding", "UTF8")
+			var keyData bytes.Buffer
+			putInt32(&keyData, 0) // backend PID
+			putInt32(&keyData, 0) // cancellation secret key
+			writeMessage(w, 'K', keyData.Bytes())
+			w.Flush()
+			return true
+		}
+	}
+}
+
+func writeParameterStatus(w *bufio.Writer, name, value string) {
+	var buf bytes.Buffer
+	putCString(&buf, name)
+	putCString(&buf, value)
+	writeMessage(w, 'S', buf.Bytes())
+}
+
+func (c *conn) pumpNotifications() {
+	for msg := range c.sub.C() {
+		c.sendNotification(msg.Channel, msg.Payload)
+	}
+}
+
+func (c *conn) lockedWrite(fn func(w *bufio.Writer)) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	fn(c.bw)
+	c.bw.Flush()
+}
+
+func (c *conn) flush() {
+	c.lockedWrite(func(w *bufio.Writer) {})
+}
+
+func (c *conn) sendReady() {
+	c.lockedWrite(func(w *bufio.Writer) { writeMessage(w, 'Z', []byte{'I'}) })
+}
+
+func (c *conn) sendNotification(channel, payload string) {
+	c.lockedWrite(func(w *bufio.Writer) {
+		var buf bytes.Buffer
+		putInt32(&buf, int32(c.id))
+		putCString(&buf, channel)
+		putCString(&buf, payload)
+		writeMessage(w, 'A', buf.Bytes())
+	})
+}
+
+func (c *conn) sendCommandComplete(tag string) {
+	c.lockedWrite(func(w *bufio.Writer) {
+		var buf bytes.Buffer
+		putCString(&buf, tag)
+		writeMessage(w, 'C', buf.Bytes())
+	})
+}
+
+func (c *conn) sendError(err error) {
+	c.lockedWrite(func(w *bufio.Writer) {
+		var buf bytes.Buffer
+		buf.WriteByte('S')
+		putCString(&buf, "ERROR")
+		buf.WriteByte('C')
+		putCString(&buf, "XX000")
+		buf.WriteByte('M')
+		putCString(&buf, err.Error())
+		buf.WriteByte(0)
+		writeMessage(w, 'E', buf.Bytes())
+	})
+}
+
+func (c *conn) sendParseComplete() {
+	c.lockedWrite(func(w *bufio.Writer) { writeMessage(w, '1', nil) })
+}
+
+func (c *conn) sendBindComplete() {
+	c.lockedWrite(func(w *bufio.Writer) { writeMessage(w, '2', nil) })
+}
+
+func (c *conn) sendCloseComplete() {
+	c.lockedWrite(func(w *bufio.Writer) { writeMessage(w, '3', nil) })
+}
+
+func (c *conn) sendNoData() {
+	c.lockedWrite(func(w *bufio.Writer) { writeMessage(w, 'n', nil) })
+}
+
+func (c *conn) sendPortalSuspended() {
+	c.lockedWrite(func(w *bufio.Writer) { writeMessage(w, 's', nil) })
+}
+
+func (c *conn) sendParameterDescription(oids []uint32) {
+	c.lockedWrite(func(w *bufio.Writer) {
+		var buf bytes.Buffer
+		putInt16(&buf, int16(len(oids)))
+		for _, oid := range oids {
+			putInt32(&buf, int32(oid))
+		}
+		writeMessage(w, 't', buf.Bytes())
+	})
+}
+
+func (c *conn) sendRowDescription(name string, oid uint32, format int16) {
+	c.lockedWrite(func(w *bufio.Writer) {
+		var buf bytes.Buffer
+		putInt16(&buf, 1)
+		putCString(&buf, name)
+		putInt32(&buf, 0)  // table OID
+		putInt16(&buf, 0)  // column attribute number
+		putInt32(&buf, int32(oid))
+		putInt16(&buf, -1) // type length: varies/unused
+		putInt32(&buf, -1) // type modifier
+		putInt16(&buf, format)
+		writeMessage(w, 'T', buf.Bytes())
+	})
+}
+
+func (c *conn) sendDataRow(oid uint32, format int16, val any) {
+	c.lockedWrite(func(w *bufio.Writer) {
+		var buf bytes.Buffer
+		putInt16(&buf, 1)
+		enc := encodeResult(oid, format, val)
+		putInt32(&buf, int32(len(enc)))
+		buf.Write(enc)
+		writeMessage(w, 'D', buf.Bytes())
+	})
+}
+
+func (c *conn) sendNullDataRow() {
+	c.lockedWrite(func(w *bufio.Writer) {
+		var buf bytes.Buffer
+		putInt16(&buf, 1)
+		putInt32(&buf, -1)
+		writeMessage(w, 'D', buf.Bytes())
+	})
+}
+
+// handleSimpleQuery serves the 'Q' message path: the non-parameterized
+// statements LISTEN, UNLISTEN, and NOTIFY, plus pogo_* calls written
+// with literal arguments (e.g. "SELECT pogo_get('somekey')") rather
+// than through Parse/Bind.
+func (c *conn) handleSimpleQuery(query string) {
+	stmt, err := parseStatement(query)
+	if err != nil {
+		c.sendError(err)
+		c.sendReady()
+		return
+	}
+	switch stmt.kind {
+	case stmtListen:
+		c.server.Broker.Subscribe(c.sub, stmt.literalChannel)
+		c.sendCommandComplete("LISTEN")
+	case stmtUnlisten:
+		if stmt.literalChannel == "*" {
+			c.server.Broker.UnsubscribeAll(c.sub)
+		} else {
+			c.server.Broker.Unsubscribe(c.sub, stmt.literalChannel)
+		}
+		c.sendCommandComplete("UNLISTEN")
+	case stmtNotify:
+		c.server.Broker.Publish(stmt.literalChannel, stmt.literalPayload)
+		c.sendCommandComplete("NOTIFY")
+	case stmtTxNoop:
+		c.sendCommandComplete(stmt.txTag)
+	default:
+		// A pogo_* statement issued without Parse/Bind; runPortal pulls
+		// its arguments from stmt.literalParams if parseStatement found
+		// any written directly in the SQL text. Unlike the extended
+		// protocol, where Describe sends the RowDescription, the simple
+		// query protocol has no separate describe step - the server
+		// must emit it itself before any DataRow.
+		if colName, oid, hasResult := stmt.kind.resultColumn(); hasResult {
+			c.sendRowDescription(colName, oid, 0)
+		}
+		c.runPortal(&portalState{stmt: stmt, resultFormats: []int16{0}}, 0)
+	}
+	c.sendReady()
+}
+
+func (c *conn) handleParse(payload []byte) {
+	name, rest := readCString(payload)
+	query, rest := readCString(rest)
+	numTypes, rest := getInt16(rest)
+	oids := make([]uint32, numTypes)
+	for i := range oids {
+		var v int32
+		v, rest = getInt32(rest)
+		oids[i] = uint32(v)
+	}
+
+	stmt, err := parseStatement(query)
+	if err != nil {
+		c.sendError(err)
+		return
+	}
+	defOIDs := defaultParamOIDs(stmt.kind, stmt.nparams)
+	for i := 0; i < len(oids) && i < len(defOIDs); i++ {
+		if oids[i] != 0 {
+			defOIDs[i] = oids[i]
+		}
+	}
+	stmt.paramOIDs = defOIDs
+
+	c.stmts[name] = stmt
+	c.sendParseComplete()
+}
+
+func (c *conn) handleBind(payload []byte) {
+	portalName, rest := readCString(payload)
+	stmtName, rest := readCString(rest)
+	stmt, ok := c.stmts[stmtName]
+	if !ok {
+		c.sendError(fmt.Errorf("postgres: unknown prepared statement %q", stmtName))
+		return
+	}
+
+	numFormats, rest := getInt16(rest)
+	formats := make([]int16, numFormats)
+	for i := range formats {
+		formats[i], rest = getInt16(rest)
+	}
+
+	numParams, rest := getInt16(rest)
+	paramFormats := expandFormats(formats, int(numParams))
+	params := make([]any, numParams)
+	for i := range params {
+		var n int32
+		n, rest = getInt32(rest)
+		if n < 0 {
+			params[i] = nil
+			continue
+		}
+		raw := rest[:n]
+		rest = rest[n:]
+		oid := uint32(oidText)
+		if i < len(stmt.paramOIDs) {
+			oid = stmt.paramOIDs[i]
+		}
+		v, err := decodeParam(oid, paramFormats[i], raw)
+		if err != nil {
+			c.sendError(err)
+			return
+		}
+		params[i] = v
+	}
+
+	numResultFormats, rest := getInt16(rest)
+	resultFormats := make([]int16, numResultFormats)
+	for i := range resultFormats {
+		resultFormats[i], rest = getInt16(rest)
+	}
+
+	c.portals[portalName] = &portalState{
+		stmt:          stmt,
+		params:        params,
+		resultFormats: expandFormats(resultFormats, 1),
+	}
+	c.sendBindComplete()
+}
+
+func (c *conn) handleDescribe(payload []byte) {
+	if len(payload) == 0 {
+		return
+	}
+	target := payload[0]
+	name, _ := readCString(payload[1:])
+
+	var stmt *preparedStmt
+	var format int16
+	if target == 'S' {
+		s, ok := c.stmts[name]
+		if !ok {
+			c.sendError(fmt.Errorf("postgres: unknown prepared statement %q", name))
+			return
+		}
+		stmt = s
+		c.sendParameterDescription(stmt.paramOIDs)
+	} else {
+		p, ok := c.portals[name]
+		if !ok {
+			c.sendError(fmt.Errorf("postgres: unknown portal %q", name))
+			return
+		}
+		stmt = p.stmt
+		format = c.resultFormat(p)
+	}
+
+	if colName, oid, hasResult := stmt.kind.resultColumn(); hasResult {
+		c.sendRowDescription(colName, oid, format)
+	} else {
+		c.sendNoData()
+	}
+}
+
+func (c *conn) handleExecute(payload []byte) {
+	portalName, rest := readCString(payload)
+	maxRows, _ := getInt32(rest)
+	portal, ok := c.portals[portalName]
+	if !ok {
+		c.sendError(fmt.Errorf("postgres: unknown portal %q", portalName))
+		return
+	}
+	c.runPortal(portal, maxRows)
+}
+
+func (c *conn) handleClose(payload []byte) {
+	if len(payload) == 0 {
+		return
+	}
+	target := payload[0]
+	name, _ := readCString(payload[1:])
+	if target == 'S' {
+		delete(c.stmts, name)
+	} else {
+		delete(c.portals, name)
+	}
+	c.sendCloseComplete()
+}
+
+func (c *conn) resultFormat(portal *portalState) int16 {
+	if len(portal.resultFormats) > 0 {
+		return portal.resultFormats[0]
+	}
+	return 0
+}
+
+// runPortal executes a bound (or ad hoc, zero-parameter) statement
+// against the shared store and writes its DataRow/CommandComplete (or
+// PortalSuspended) response.
+func (c *conn) runPortal(portal *portalState, maxRows int32) {
+	stmt := portal.stmt
+	format := c.resultFormat(portal)
+
+	// A statement with no $N placeholders never goes through Bind with
+	// real parameter values, so fall back to the literal arguments
+	// parseStatement extracted straight out of the call's SQL text
+	// (e.g. "pogo_get('somekey')") - otherwise every field below would
+	// come up empty/zero, as if the call had been made with no
+	// arguments at all.
+	params := portal.params
+	if len(params) == 0 && len(stmt.literalParams) > 0 {
+		params = stmt.literalParams
+	}
+
+	// asString/asInt64/asTime type-assert a bound parameter instead of
+	// panicking on a mismatch: a client can Parse a statement with the
+	// wrong OID for a parameter (e.g. int8 for pogo_get's key), which
+	// makes decodeParam hand back a Go type runPortal doesn't expect.
+	asString := func(i int) (string, error) {
+		if i >= len(params) || params[i] == nil {
+			return "", nil
+		}
+		v, ok := params[i].(string)
+		if !ok {
+			return "", fmt.Errorf("postgres: parameter $%d: expected text, got %T", i+1, params[i])
+		}
+		return v, nil
+	}
+	asInt64 := func(i int) (int64, error) {
+		if i >= len(params) || params[i] == nil {
+			return 0, nil
+		}
+		v, ok := params[i].(int64)
+		if !ok {
+			return 0, fmt.Errorf("postgres: parameter $%d: expected int8, got %T", i+1, params[i])
+		}
+		return v, nil
+	}
+	asTime := func(i int) (time.Time, error) {
+		if i >= len(params) || params[i] == nil {
+			return time.Time{}, nil
+		}
+		v, ok := params[i].(time.Time)
+		if !ok {
+			return time.Time{}, fmt.Errorf("postgres: parameter $%d: expected timestamptz, got %T", i+1, params[i])
+		}
+		return v, nil
+	}
+
+	switch stmt.kind {
+	case stmtTxNoop:
+		c.sendCommandComplete(stmt.txTag)
+
+	case stmtGet:
+		key, err := asString(0)
+		if err != nil {
+			c.sendError(err)
+			return
+		}
+		e, ok := c.server.Store.Get(key)
+		if !ok {
+			c.sendCommandComplete("SELECT 0")
+			return
+		}
+		c.sendDataRow(oidText, format, string(e.Value))
+		c.sendCommandComplete("SELECT 1")
+
+	case stmtSet:
+		key, err := asString(0)
+		if err != nil {
+			c.sendError(err)
+			return
+		}
+		val, err := asString(1)
+		if err != nil {
+			c.sendError(err)
+			return
+		}
+		var ttl time.Duration
+		if len(params) > 2 && params[2] != nil {
+			secs, err := asInt64(2)
+			if err != nil {
+				c.sendError(err)
+				return
+			}
+			ttl = time.Duration(secs) * time.Second
+		}
+		c.server.Store.Set(key, []byte(val), 0, ttl)
+		c.sendCommandComplete("CALL")
+
+	case stmtDel:
+		key, err := asString(0)
+		if err != nil {
+			c.sendError(err)
+			return
+		}
+		c.server.Store.Delete(key)
+		c.sendCommandComplete("CALL")
+
+	case stmtIncr:
+		key, err := asString(0)
+		if err != nil {
+			c.sendError(err)
+			return
+		}
+		delta, err := asInt64(1)
+		if err != nil {
+			c.sendError(err)
+			return
+		}
+		// pogo_incr auto-vivifies to the delta itself (unlike the
+		// memcached meta ma command, which seeds a separate initial
+		// value unaffected by delta).
+		n, err := c.server.Store.Incr(key, delta, true, delta, 0)
+		if err != nil {
+			c.sendError(err)
+			return
+		}
+		c.sendDataRow(oidInt8, format, n)
+		c.sendCommandComplete("SELECT 1")
+
+	case stmtExpire:
+		key, err := asString(0)
+		if err != nil {
+			c.sendError(err)
+			return
+		}
+		var ttl time.Duration
+		if len(params) > 1 && params[1] != nil {
+			at, err := asTime(1)
+			if err != nil {
+				c.sendError(err)
+				return
+			}
+			ttl = time.Until(at)
+		}
+		c.server.Store.Expire(key, ttl)
+		c.sendCommandComplete("CALL")
+
+	case stmtScan:
+		if portal.cursor == nil {
+			prefix, err := asString(0)
+			if err != nil {
+				c.sendError(err)
+				return
+			}
+			portal.cursor = &scanCursor{keys: c.server.Store.Keys(prefix)}
+		}
+		limit := len(portal.cursor.keys) - portal.cursor.pos
+		if maxRows > 0 && int(maxRows) < limit {
+			limit = int(maxRows)
+		}
+		for i := 0; i < limit; i++ {
+			c.sendDataRow(oidText, format, portal.cursor.keys[portal.cursor.pos])
+			portal.cursor.pos++
+		}
+		if portal.cursor.pos < len(portal.cursor.keys) {
+			c.sendPortalSuspended()
+		} else {
+			c.sendCommandComplete(fmt.Sprintf("SELECT %d", portal.cursor.pos))
+		}
+
+	case stmtMGet:
+		for _, p := range params {
+			key, _ := p.(string)
+			if e, ok := c.server.Store.Get(key); ok {
+				c.sendDataRow(oidText, format, string(e.Value))
+			} else {
+				c.sendNullDataRow()
+			}
+		}
+		c.sendCommandComplete(fmt.Sprintf("SELECT %d", len(params)))
+
+	case stmtMSet:
+		for i := 0; i+1 < len(params); i += 2 {
+			key, _ := params[i].(string)
+			val, _ := params[i+1].(string)
+			c.server.Store.Set(key, []byte(val), 0, 0)
+		}
+		c.sendCommandComplete("CALL")
+	}
+}
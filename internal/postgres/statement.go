@@ -0,0 +1,263 @@
+package postgres
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Well-known PostgreSQL type OIDs for the parameter/result types this
+// frontend understands.
+const (
+	oidText        = 25
+	oidInt8        = 20
+	oidTimestamptz = 1184
+)
+
+type stmtKind int
+
+const (
+	stmtListen stmtKind = iota
+	stmtUnlisten
+	stmtNotify
+	stmtGet
+	stmtSet
+	stmtDel
+	stmtIncr
+	stmtExpire
+	stmtScan
+	stmtMGet
+	stmtMSet
+	stmtTxNoop
+)
+
+// preparedStmt is the result of parsing a query string from Parse (or a
+// simple-query string), mapping it onto the fixed pogo_* grammar.
+type preparedStmt struct {
+	kind    stmtKind
+	nparams int
+
+	// Only set for the literal-channel, no-parameter LISTEN/UNLISTEN/
+	// NOTIFY statements, which are driven by the simple query protocol.
+	literalChannel string
+	literalPayload string
+
+	// Only set for stmtTxNoop: the command tag to reply with. The
+	// engine has no multi-statement transaction semantics, so
+	// BEGIN/COMMIT/ROLLBACK are accepted and otherwise ignored.
+	txTag string
+
+	paramOIDs []uint32
+
+	// literalParams holds the arguments a simple-query pogo_* call
+	// (e.g. "SELECT pogo_get('somekey')") wrote directly in the SQL
+	// text, for statements with no $N placeholders to Bind against.
+	// runPortal falls back to these when no Bind-supplied params are
+	// present.
+	literalParams []any
+}
+
+var paramPattern = regexp.MustCompile(`\$(\d+)`)
+
+func countParams(sql string) int {
+	max := 0
+	for _, m := range paramPattern.FindAllStringSubmatch(sql, -1) {
+		if n, err := strconv.Atoi(m[1]); err == nil && n > max {
+			max = n
+		}
+	}
+	return max
+}
+
+func containsFunc(upperSQL, name string) bool {
+	return strings.Contains(upperSQL, name)
+}
+
+// parseStatement maps a query string onto the fixed set of pogo_*
+// functions/procedures (get, set, del, incr, expire, mget, mset, scan)
+// or, for the simple-query-only LISTEN/UNLISTEN/NOTIFY forms, extracts
+// their literal (non-parameterized) channel and payload.
+func parseStatement(sql string) (*preparedStmt, error) {
+	s := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(sql), ";"))
+	upper := strings.ToUpper(s)
+
+	switch upper {
+	case "BEGIN", "START TRANSACTION":
+		return &preparedStmt{kind: stmtTxNoop, txTag: "BEGIN"}, nil
+	case "COMMIT", "END":
+		return &preparedStmt{kind: stmtTxNoop, txTag: "COMMIT"}, nil
+	case "ROLLBACK":
+		return &preparedStmt{kind: stmtTxNoop, txTag: "ROLLBACK"}, nil
+	}
+
+	switch {
+	case strings.HasPrefix(upper, "LISTEN "):
+		return &preparedStmt{kind: stmtListen, literalChannel: strings.TrimSpace(s[len("LISTEN "):])}, nil
+	case strings.HasPrefix(upper, "UNLISTEN "):
+		return &preparedStmt{kind: stmtUnlisten, literalChannel: strings.TrimSpace(s[len("UNLISTEN "):])}, nil
+	case strings.HasPrefix(upper, "NOTIFY "):
+		rest := strings.TrimSpace(s[len("NOTIFY "):])
+		parts := strings.SplitN(rest, ",", 2)
+		channel := strings.Trim(strings.TrimSpace(parts[0]), `"`)
+		payload := ""
+		if len(parts) == 2 {
+			payload = strings.Trim(strings.TrimSpace(parts[1]), "'")
+		}
+		return &preparedStmt{kind: stmtNotify, literalChannel: channel, literalPayload: payload}, nil
+	}
+
+	name := firstMatchingFunc(upper)
+	kind, ok := map[string]stmtKind{
+		"POGO_GET":    stmtGet,
+		"POGO_SET":    stmtSet,
+		"POGO_DEL":    stmtDel,
+		"POGO_INCR":   stmtIncr,
+		"POGO_EXPIRE": stmtExpire,
+		"POGO_SCAN":   stmtScan,
+		"POGO_MGET":   stmtMGet,
+		"POGO_MSET":   stmtMSet,
+	}[name]
+	if !ok {
+		return nil, fmt.Errorf("pogocache: unsupported statement: %s", s)
+	}
+
+	stmt := &preparedStmt{kind: kind, nparams: countParams(s)}
+	if stmt.nparams == 0 {
+		// No $N placeholders means this call will never go through
+		// Bind with real values, so any arguments must be literal
+		// text straight in the SQL (e.g. "pogo_get('somekey')") -
+		// parse them out now rather than silently running every call
+		// as if it had none.
+		params, err := literalArgs(s, name)
+		if err != nil {
+			return nil, err
+		}
+		stmt.literalParams = params
+	}
+	return stmt, nil
+}
+
+// literalArgs parses the parenthesized argument list immediately
+// following name in sql (e.g. "pogo_set('key', 'val', 60)") into the
+// same shape decodeParam produces for a Bind parameter: each argument
+// is a Go string (for a quoted literal, with '' unescaped to ') or an
+// int64 (for a bare integer). It returns nil, nil if name isn't
+// followed by an argument list at all.
+func literalArgs(sql, name string) ([]any, error) {
+	upper := strings.ToUpper(sql)
+	idx := strings.Index(upper, name)
+	if idx < 0 {
+		return nil, nil
+	}
+	rest := strings.TrimSpace(sql[idx+len(name):])
+	if !strings.HasPrefix(rest, "(") {
+		return nil, nil
+	}
+	end := strings.LastIndex(rest, ")")
+	if end < 0 {
+		return nil, fmt.Errorf("pogocache: unterminated argument list in: %s", sql)
+	}
+	argList := strings.TrimSpace(rest[1:end])
+	if argList == "" {
+		return nil, nil
+	}
+
+	tokens := splitTopLevelArgs(argList)
+	params := make([]any, len(tokens))
+	for i, tok := range tokens {
+		v, err := literalArg(strings.TrimSpace(tok))
+		if err != nil {
+			return nil, err
+		}
+		params[i] = v
+	}
+	return params, nil
+}
+
+// literalArg parses a single argument token: a single-quoted string
+// literal or a bare integer are the only literal forms the pogo_*
+// simple-query call shapes need.
+func literalArg(tok string) (any, error) {
+	if strings.HasPrefix(tok, "'") {
+		if len(tok) < 2 || !strings.HasSuffix(tok, "'") {
+			return nil, fmt.Errorf("pogocache: unterminated string literal: %s", tok)
+		}
+		return strings.ReplaceAll(tok[1:len(tok)-1], "''", "'"), nil
+	}
+	if n, err := strconv.ParseInt(tok, 10, 64); err == nil {
+		return n, nil
+	}
+	return nil, fmt.Errorf("pogocache: unsupported literal argument %q (expected a quoted string or an integer)", tok)
+}
+
+// splitTopLevelArgs splits a comma-separated argument list, ignoring
+// commas inside single-quoted string literals.
+func splitTopLevelArgs(s string) []string {
+	var args []string
+	var b strings.Builder
+	inQuote := false
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\'':
+			inQuote = !inQuote
+			b.WriteByte(c)
+		case c == ',' && !inQuote:
+			args = append(args, b.String())
+			b.Reset()
+		default:
+			b.WriteByte(c)
+		}
+	}
+	args = append(args, b.String())
+	return args
+}
+
+func firstMatchingFunc(upper string) string {
+	for _, name := range []string{"POGO_GET", "POGO_SET", "POGO_DEL", "POGO_INCR", "POGO_EXPIRE", "POGO_SCAN", "POGO_MGET", "POGO_MSET"} {
+		if containsFunc(upper, name) {
+			return name
+		}
+	}
+	return ""
+}
+
+func (k stmtKind) resultColumn() (name string, oid uint32, hasResult bool) {
+	switch k {
+	case stmtGet:
+		return "pogo_get", oidText, true
+	case stmtScan:
+		return "pogo_scan", oidText, true
+	case stmtMGet:
+		return "pogo_mget", oidText, true
+	case stmtIncr:
+		return "pogo_incr", oidInt8, true
+	default:
+		return "", 0, false
+	}
+}
+
+// defaultParamOIDs fills in OIDs for a statement's n parameters
+// following the pogo_* grammar's fixed shapes, used whenever Parse
+// didn't pin down a concrete type itself.
+func defaultParamOIDs(kind stmtKind, n int) []uint32 {
+	oids := make([]uint32, n)
+	for i := range oids {
+		oids[i] = oidText
+	}
+	switch kind {
+	case stmtSet:
+		if n >= 3 {
+			oids[2] = oidInt8 // ttl seconds
+		}
+	case stmtIncr:
+		if n >= 2 {
+			oids[1] = oidInt8 // delta
+		}
+	case stmtExpire:
+		if n >= 2 {
+			oids[1] = oidTimestamptz
+		}
+	}
+	return oids
+}
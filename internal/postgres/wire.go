@@ -0,0 +1,108 @@
+package postgres
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// readStartupPacket reads the length-prefixed, type-byte-less packet
+// that opens every Postgres connection (SSLRequest, CancelRequest, or
+// the real StartupMessage), returning its payload.
+func readStartupPacket(r *bufio.Reader) ([]byte, error) {
+	var lenBuf [4]byte
+	if _, err := io.ReadFull(r, lenBuf[:]); err != nil {
+		return nil, err
+	}
+	n := int32(binary.BigEndian.Uint32(lenBuf[:])) - 4
+	if n < 0 {
+		return nil, fmt.Errorf("postgres: negative startup packet length")
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+// readMessage reads one type-tagged, length-prefixed protocol message.
+func readMessage(r *bufio.Reader) (byte, []byte, error) {
+	typ, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	var lenBuf [4]byte
+	if _, err := io.ReadFull(r, lenBuf[:]); err != nil {
+		return 0, nil, err
+	}
+	n := int32(binary.BigEndian.Uint32(lenBuf[:])) - 4
+	if n < 0 {
+		return 0, nil, fmt.Errorf("postgres: negative message length")
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	return typ, payload, nil
+}
+
+func writeMessage(w *bufio.Writer, typ byte, payload []byte) {
+	w.WriteByte(typ)
+	var lenBuf [4]byte
+	binary.BigEndian.PutUint32(lenBuf[:], uint32(len(payload)+4))
+	w.Write(lenBuf[:])
+	w.Write(payload)
+}
+
+func readCString(buf []byte) (s string, rest []byte) {
+	i := bytes.IndexByte(buf, 0)
+	if i < 0 {
+		return string(buf), nil
+	}
+	return string(buf[:i]), buf[i+1:]
+}
+
+func putCString(buf *bytes.Buffer, s string) {
+	buf.WriteString(s)
+	buf.WriteByte(0)
+}
+
+func putInt16(buf *bytes.Buffer, n int16) {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], uint16(n))
+	buf.Write(b[:])
+}
+
+func putInt32(buf *bytes.Buffer, n int32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(n))
+	buf.Write(b[:])
+}
+
+func getInt16(buf []byte) (int16, []byte) {
+	return int16(binary.BigEndian.Uint16(buf[:2])), buf[2:]
+}
+
+func getInt32(buf []byte) (int32, []byte) {
+	return int32(binary.BigEndian.Uint32(buf[:4])), buf[4:]
+}
+
+// expandFormats expands the (possibly 0- or 1-length) format-code list
+// Bind sends into one entry per value, per the wire protocol's "apply to
+// all" shorthand.
+func expandFormats(formats []int16, n int) []int16 {
+	switch len(formats) {
+	case 0:
+		return make([]int16, n) // all text (0)
+	case 1:
+		out := make([]int16, n)
+		for i := range out {
+			out[i] = formats[0]
+		}
+		return out
+	default:
+		return formats
+	}
+}
@@ -0,0 +1,129 @@
+// Package pubsub implements the channel broker shared by every protocol
+// frontend. Redis PUBLISH/SUBSCRIBE, Postgres NOTIFY/LISTEN, and RESP3
+// client-tracking invalidations all publish and subscribe through the
+// same Broker instance, which is what lets a message published from one
+// protocol reach a listener connected via another.
+package pubsub
+
+import "sync"
+
+// Message is a single published event.
+type Message struct {
+	Channel string
+	Payload string
+}
+
+// Subscriber receives messages for whatever channels it's subscribed to
+// via a Broker. ClientID identifies the owning connection for frontends
+// that expose their own client-id concept (currently unused for
+// delivery decisions, but kept for parity with the tracking subsystem).
+type Subscriber struct {
+	ClientID uint64
+
+	ch       chan Message
+	mu       sync.Mutex
+	channels map[string]bool
+}
+
+// NewSubscriber creates a Subscriber for the given client id with a
+// bounded inbox; slow readers drop messages rather than stall a
+// publisher.
+func NewSubscriber(clientID uint64) *Subscriber {
+	return &Subscriber{
+		ClientID: clientID,
+		ch:       make(chan Message, 64),
+		channels: make(map[string]bool),
+	}
+}
+
+// C returns the channel new messages arrive on.
+func (s *Subscriber) C() <-chan Message {
+	return s.ch
+}
+
+// Broker fans published messages out to subscribed Subscribers.
+type Broker struct {
+	mu   sync.Mutex
+	subs map[string]map[*Subscriber]bool
+}
+
+// NewBroker returns an empty Broker.
+func NewBroker() *Broker {
+	return &Broker{subs: make(map[string]map[*Subscriber]bool)}
+}
+
+// Subscribe registers sub to receive messages published on channel.
+func (b *Broker) Subscribe(sub *Subscriber, channel string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subs[channel] == nil {
+		b.subs[channel] = make(map[*Subscriber]bool)
+	}
+	b.subs[channel][sub] = true
+
+	sub.mu.Lock()
+	sub.channels[channel] = true
+	sub.mu.Unlock()
+}
+
+// Unsubscribe removes sub from channel.
+func (b *Broker) Unsubscribe(sub *Subscriber, channel string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.removeLocked(sub, channel)
+
+	sub.mu.Lock()
+	delete(sub.channels, channel)
+	sub.mu.Unlock()
+}
+
+// UnsubscribeAll removes sub from every channel it's currently on,
+// which is what a connection close or a bare "UNLISTEN *" needs.
+func (b *Broker) UnsubscribeAll(sub *Subscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sub.mu.Lock()
+	channels := make([]string, 0, len(sub.channels))
+	for ch := range sub.channels {
+		channels = append(channels, ch)
+	}
+	sub.channels = make(map[string]bool)
+	sub.mu.Unlock()
+
+	for _, ch := range channels {
+		b.removeLocked(sub, ch)
+	}
+}
+
+func (b *Broker) removeLocked(sub *Subscriber, channel string) {
+	m, ok := b.subs[channel]
+	if !ok {
+		return
+	}
+	delete(m, sub)
+	if len(m) == 0 {
+		delete(b.subs, channel)
+	}
+}
+
+// Publish delivers payload to every current subscriber of channel and
+// returns how many subscribers received it.
+func (b *Broker) Publish(channel, payload string) int {
+	b.mu.Lock()
+	recipients := make([]*Subscriber, 0, len(b.subs[channel]))
+	for sub := range b.subs[channel] {
+		recipients = append(recipients, sub)
+	}
+	b.mu.Unlock()
+
+	for _, sub := range recipients {
+		select {
+		case sub.ch <- Message{Channel: channel, Payload: payload}:
+		default:
+			// Subscriber's inbox is full; drop rather than block the
+			// publisher on a slow reader.
+		}
+	}
+	return len(recipients)
+}
@@ -0,0 +1,490 @@
+// Package resp implements the Redis frontend: RESP2 and RESP3 (HELLO
+// negotiation, the RESP3 reply types, and RESP3-only server-assisted
+// client-side caching via CLIENT TRACKING). It shares its keyspace and
+// pub/sub broker with the other frontends so cross-protocol pub/sub and
+// LISTEN/NOTIFY bridging works transparently.
+package resp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tidwall/pogocache/internal/pubsub"
+	"github.com/tidwall/pogocache/internal/store"
+	"github.com/tidwall/pogocache/internal/tracking"
+)
+
+// invalidateChannel is the channel name tracking invalidation pushes are
+// reported under. Unlike a regular pub/sub channel, clients don't
+// SUBSCRIBE to it: a push arrives unsolicited on the connection that
+// enabled tracking (or its REDIRECT target), delivered directly rather
+// than through the broker - see (*conn).invalidate.
+const invalidateChannel = "__redis__:invalidate"
+
+// Server serves the Redis (RESP) protocol over a net.Listener.
+type Server struct {
+	Store   *store.Store
+	Broker  *pubsub.Broker
+	Tracker *tracking.Tracker
+
+	nextID atomic.Uint64
+
+	mu    sync.Mutex
+	conns map[uint64]*conn
+}
+
+// NewServer wires up a Server sharing the given keyspace, pub/sub
+// broker, and tracking bookkeeping with other frontends.
+func NewServer(st *store.Store, broker *pubsub.Broker, trk *tracking.Tracker) *Server {
+	return &Server{Store: st, Broker: broker, Tracker: trk, conns: make(map[uint64]*conn)}
+}
+
+func (s *Server) registerConn(c *conn) {
+	s.mu.Lock()
+	s.conns[c.id] = c
+	s.mu.Unlock()
+}
+
+func (s *Server) unregisterConn(id uint64) {
+	s.mu.Lock()
+	delete(s.conns, id)
+	s.mu.Unlock()
+}
+
+// lookupConn finds the connection for a tracking target client id, which
+// may be a different connection than the one that triggered the write
+// (REDIRECT).
+func (s *Server) lookupConn(id uint64) (*conn, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.conns[id]
+	return c, ok
+}
+
+// Serve accepts connections from ln until it returns an error (e.g. the
+// listener was closed).
+func (s *Server) Serve(ln net.Listener) error {
+	for {
+		nc, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go s.serveConn(nc)
+	}
+}
+
+type conn struct {
+	server *Server
+	id     uint64
+	nc     net.Conn
+	bw     *bufio.Writer
+	wmu    sync.Mutex
+	proto  int // 2 or 3, negotiated via HELLO
+	sub    *pubsub.Subscriber
+}
+
+func (s *Server) serveConn(nc net.Conn) {
+	defer nc.Close()
+
+	c := &conn{
+		server: s,
+		id:     s.nextID.Add(1),
+		nc:     nc,
+		bw:     bufio.NewWriter(nc),
+		proto:  2,
+	}
+	c.sub = pubsub.NewSubscriber(c.id)
+
+	s.registerConn(c)
+	go c.pumpMessages()
+
+	defer func() {
+		s.unregisterConn(c.id)
+		s.Broker.UnsubscribeAll(c.sub)
+		s.Tracker.Disable(c.id)
+	}()
+
+	r := bufio.NewReader(nc)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		c.dispatch(args)
+	}
+}
+
+// pumpMessages forwards whatever this connection's subscriber receives
+// via regular PUBLISH traffic out over the wire as pub/sub "message"
+// frames. Tracking invalidations bypass this path entirely - see
+// (*conn).invalidate - since they must reach only the resolved target
+// connection, not every subscriber of invalidateChannel.
+func (c *conn) pumpMessages() {
+	for msg := range c.sub.C() {
+		c.replyMessage(msg.Channel, msg.Payload)
+	}
+}
+
+// readCommand reads one RESP array-of-bulk-strings request, which is
+// the only encoding real clients (redigo, go-redis) send requests in.
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return nil, nil
+	}
+	if line[0] != '*' {
+		return nil, fmt.Errorf("resp: expected array, got %q", line)
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("resp: bad array length %q", line)
+	}
+	args := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		hdr, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		hdr = strings.TrimRight(hdr, "\r\n")
+		if len(hdr) == 0 || hdr[0] != '$' {
+			return nil, fmt.Errorf("resp: expected bulk string, got %q", hdr)
+		}
+		n, err := strconv.Atoi(hdr[1:])
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("resp: bad bulk length %q", hdr)
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args = append(args, string(buf[:n]))
+	}
+	return args, nil
+}
+
+func (c *conn) lockedWrite(fn func(w *bufio.Writer)) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	fn(c.bw)
+	c.bw.Flush()
+}
+
+func writeBulk(w *bufio.Writer, s string) {
+	fmt.Fprintf(w, "$%d\r\n%s\r\n", len(s), s)
+}
+
+func writeNullBulk(w *bufio.Writer, proto int) {
+	if proto >= 3 {
+		fmt.Fprint(w, "_\r\n")
+	} else {
+		fmt.Fprint(w, "$-1\r\n")
+	}
+}
+
+func (c *conn) replySimple(s string) {
+	c.lockedWrite(func(w *bufio.Writer) { fmt.Fprintf(w, "+%s\r\n", s) })
+}
+
+func (c *conn) replyError(format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	c.lockedWrite(func(w *bufio.Writer) { fmt.Fprintf(w, "-ERR %s\r\n", msg) })
+}
+
+func (c *conn) replyInt(n int64) {
+	c.lockedWrite(func(w *bufio.Writer) { fmt.Fprintf(w, ":%d\r\n", n) })
+}
+
+func (c *conn) replyBulk(s string) {
+	c.lockedWrite(func(w *bufio.Writer) { writeBulk(w, s) })
+}
+
+func (c *conn) replyNullBulk() {
+	c.lockedWrite(func(w *bufio.Writer) { writeNullBulk(w, c.proto) })
+}
+
+// replyPushFrame writes an N-element frame using the Push type ('>')
+// for RESP3 connections and a plain array for RESP2 ones - the shape
+// pub/sub confirmations and messages both use.
+func (c *conn) replyPushFrame(elems []string, ints map[int]int64) {
+	c.lockedWrite(func(w *bufio.Writer) {
+		if c.proto >= 3 {
+			fmt.Fprintf(w, ">%d\r\n", len(elems))
+		} else {
+			fmt.Fprintf(w, "*%d\r\n", len(elems))
+		}
+		for i, e := range elems {
+			if n, ok := ints[i]; ok {
+				fmt.Fprintf(w, ":%d\r\n", n)
+			} else {
+				writeBulk(w, e)
+			}
+		}
+	})
+}
+
+func (c *conn) replySubscribeConfirm(kind, channel string, count int) {
+	c.replyPushFrame([]string{kind, channel, ""}, map[int]int64{2: int64(count)})
+}
+
+func (c *conn) replyMessage(channel, payload string) {
+	c.replyPushFrame([]string{"message", channel, payload}, nil)
+}
+
+func (c *conn) dispatch(args []string) {
+	cmd := strings.ToUpper(args[0])
+	switch cmd {
+	case "PING":
+		if len(args) > 1 {
+			c.replyBulk(args[1])
+		} else {
+			c.replySimple("PONG")
+		}
+	case "HELLO":
+		c.handleHello(args[1:])
+	case "SET":
+		c.handleSet(args[1:])
+	case "GET":
+		c.handleGet(args[1:])
+	case "DEL":
+		c.handleDel(args[1:])
+	case "PUBLISH":
+		c.handlePublish(args[1:])
+	case "SUBSCRIBE":
+		c.handleSubscribe(args[1:])
+	case "UNSUBSCRIBE":
+		c.handleUnsubscribe(args[1:])
+	case "CLIENT":
+		c.handleClient(args[1:])
+	default:
+		c.replyError("unknown command %q", args[0])
+	}
+}
+
+func (c *conn) handleHello(args []string) {
+	proto := c.proto
+	if len(args) > 0 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || (n != 2 && n != 3) {
+			c.replyError("unsupported HELLO protocol version %q", args[0])
+			return
+		}
+		proto = n
+	}
+	c.proto = proto
+
+	pairs := []struct {
+		key string
+		val string
+		num int64
+		isN bool
+	}{
+		{key: "server", val: "pogocache"},
+		{key: "version", val: "7.4.0"},
+		{key: "proto", num: int64(proto), isN: true},
+		{key: "id", num: int64(c.id), isN: true},
+		{key: "mode", val: "standalone"},
+		{key: "role", val: "master"},
+	}
+	c.lockedWrite(func(w *bufio.Writer) {
+		if c.proto >= 3 {
+			fmt.Fprintf(w, "%%%d\r\n", len(pairs)+1)
+		} else {
+			fmt.Fprintf(w, "*%d\r\n", (len(pairs)+1)*2)
+		}
+		for _, p := range pairs {
+			writeBulk(w, p.key)
+			if p.isN {
+				fmt.Fprintf(w, ":%d\r\n", p.num)
+			} else {
+				writeBulk(w, p.val)
+			}
+		}
+		writeBulk(w, "modules")
+		fmt.Fprint(w, "*0\r\n")
+	})
+}
+
+func (c *conn) handleSet(args []string) {
+	if len(args) < 2 {
+		c.replyError("wrong number of arguments for 'set' command")
+		return
+	}
+	key, value := args[0], args[1]
+	var ttl int64
+	for i := 2; i < len(args); i++ {
+		if strings.EqualFold(args[i], "EX") && i+1 < len(args) {
+			ttl, _ = strconv.ParseInt(args[i+1], 10, 64)
+			i++
+		}
+	}
+	c.server.Store.Set(key, []byte(value), 0, time.Duration(ttl)*time.Second)
+	c.invalidate(key)
+	c.replySimple("OK")
+}
+
+func (c *conn) handleGet(args []string) {
+	if len(args) != 1 {
+		c.replyError("wrong number of arguments for 'get' command")
+		return
+	}
+	e, ok := c.server.Store.Get(args[0])
+	if !ok {
+		c.replyNullBulk()
+		return
+	}
+	c.server.Tracker.Track(c.id, args[0])
+	c.replyBulk(string(e.Value))
+}
+
+func (c *conn) handleDel(args []string) {
+	var n int64
+	for _, key := range args {
+		if c.server.Store.Delete(key) {
+			n++
+		}
+		c.invalidate(key)
+	}
+	c.replyInt(n)
+}
+
+func (c *conn) handlePublish(args []string) {
+	if len(args) != 2 {
+		c.replyError("wrong number of arguments for 'publish' command")
+		return
+	}
+	n := c.server.Broker.Publish(args[0], args[1])
+	c.replyInt(int64(n))
+}
+
+func (c *conn) handleSubscribe(args []string) {
+	if len(args) == 0 {
+		c.replyError("wrong number of arguments for 'subscribe' command")
+		return
+	}
+	for _, ch := range args {
+		c.server.Broker.Subscribe(c.sub, ch)
+		c.replySubscribeConfirm("subscribe", ch, 1)
+	}
+}
+
+func (c *conn) handleUnsubscribe(args []string) {
+	for _, ch := range args {
+		c.server.Broker.Unsubscribe(c.sub, ch)
+		c.replySubscribeConfirm("unsubscribe", ch, 0)
+	}
+}
+
+func (c *conn) handleClient(args []string) {
+	if len(args) == 0 {
+		c.replyError("wrong number of arguments for 'client' command")
+		return
+	}
+	switch strings.ToUpper(args[0]) {
+	case "ID":
+		c.replyInt(int64(c.id))
+	case "TRACKING":
+		c.handleClientTracking(args[1:])
+	case "SETINFO":
+		// Client identity metadata (library name/version); nothing to
+		// act on, but real clients expect this to succeed.
+		c.replySimple("OK")
+	default:
+		c.replyError("unknown CLIENT subcommand %q", args[0])
+	}
+}
+
+// handleClientTracking parses:
+//
+//	CLIENT TRACKING ON|OFF [REDIRECT id] [BCAST [PREFIX p ...]] [OPTIN|OPTOUT] [NOLOOP]
+//
+// OPTIN/OPTOUT/NOLOOP are accepted but not distinguished from the
+// default (OPTOUT-equivalent) behavior: every GET the client issues is
+// tracked unless it's in BCAST mode.
+func (c *conn) handleClientTracking(args []string) {
+	if len(args) == 0 {
+		c.replyError("wrong number of arguments for 'client|tracking' command")
+		return
+	}
+	mode := strings.ToUpper(args[0])
+	if mode == "OFF" {
+		c.server.Tracker.Disable(c.id)
+		c.replySimple("OK")
+		return
+	}
+	if mode != "ON" {
+		c.replyError("expected ON or OFF for 'client|tracking'")
+		return
+	}
+
+	var redirectTo uint64
+	var bcast bool
+	var prefixes []string
+	for i := 1; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "REDIRECT":
+			i++
+			if i >= len(args) {
+				c.replyError("REDIRECT requires an argument")
+				return
+			}
+			id, err := strconv.ParseUint(args[i], 10, 64)
+			if err != nil {
+				c.replyError("invalid REDIRECT client id %q", args[i])
+				return
+			}
+			redirectTo = id
+		case "BCAST":
+			bcast = true
+		case "PREFIX":
+			i++
+			if i >= len(args) {
+				c.replyError("PREFIX requires an argument")
+				return
+			}
+			prefixes = append(prefixes, args[i])
+		case "OPTIN", "OPTOUT", "NOLOOP":
+			// Accepted, not differentiated from default behavior.
+		default:
+			c.replyError("unknown CLIENT TRACKING option %q", args[i])
+			return
+		}
+	}
+
+	var bcastPrefixes []string
+	if bcast {
+		bcastPrefixes = prefixes
+		if len(bcastPrefixes) == 0 {
+			bcastPrefixes = []string{""} // BCAST with no PREFIX matches every key
+		}
+	}
+	c.server.Tracker.Enable(c.id, redirectTo, bcastPrefixes)
+	c.replySimple("OK")
+}
+
+// invalidate notifies exactly the tracking clients interested in key,
+// each by writing directly to its own connection rather than publishing
+// on invalidateChannel through the broker: a broker-wide publish would
+// reach every connection subscribed to that channel, not just the ones
+// Tracker.Invalidate actually resolved (the normal case with more than
+// one CLIENT TRACKING client connected at once).
+func (c *conn) invalidate(key string) {
+	for id := range c.server.Tracker.Invalidate(key) {
+		if target, ok := c.server.lookupConn(id); ok {
+			target.replyMessage(invalidateChannel, key)
+		}
+	}
+}
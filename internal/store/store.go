@@ -0,0 +1,227 @@
+// Package store implements the in-memory keyspace shared by every
+// protocol frontend (RESP, Memcached, Postgres). Each key maps to a
+// single Entry carrying the value, opaque client flags, a CAS token for
+// conditional writes, and an optional absolute expiry time.
+package store
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned when an operation requires an existing key
+// that isn't present (or has expired).
+var ErrNotFound = errors.New("store: key not found")
+
+// ErrCASMismatch is returned by SetCAS when the supplied CAS token no
+// longer matches the stored entry, meaning the value changed since it
+// was last read.
+var ErrCASMismatch = errors.New("store: cas mismatch")
+
+// Entry is a snapshot of a stored value. Methods on Store return copies
+// so callers can't mutate the live entry without going through Store.
+type Entry struct {
+	Value      []byte
+	Flags      uint32
+	Cas        uint64
+	ExpireAt   time.Time // zero value means no expiry
+	LastAccess time.Time // last time this entry was read via GetAndTouch
+	Hit        bool      // whether this entry has been read via GetAndTouch since it was last stored
+}
+
+func (e *Entry) expired(now time.Time) bool {
+	return !e.ExpireAt.IsZero() && now.After(e.ExpireAt)
+}
+
+// Store is a single shared, thread-safe keyspace. Expiry is checked
+// lazily on access rather than via a background sweep.
+type Store struct {
+	mu   sync.Mutex
+	data map[string]*Entry
+	cas  uint64
+}
+
+// New returns an empty Store.
+func New() *Store {
+	return &Store{data: make(map[string]*Entry)}
+}
+
+func (s *Store) nextCas() uint64 {
+	s.cas++
+	return s.cas
+}
+
+// getLocked returns the live entry for key, evicting it first if it has
+// expired. Callers must hold s.mu.
+func (s *Store) getLocked(key string) (*Entry, bool) {
+	e, ok := s.data[key]
+	if !ok {
+		return nil, false
+	}
+	if e.expired(time.Now()) {
+		delete(s.data, key)
+		return nil, false
+	}
+	return e, true
+}
+
+// Get returns a copy of the entry stored at key.
+func (s *Store) Get(key string) (Entry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.getLocked(key)
+	if !ok {
+		return Entry{}, false
+	}
+	return *e, true
+}
+
+// GetAndTouch returns a copy of the entry at key as it existed prior to
+// this access - so a caller can report whether it was hit before and
+// how long ago it was last accessed, as the memcached meta protocol's
+// h/l flags do - then marks the live entry as accessed.
+func (s *Store) GetAndTouch(key string) (Entry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.getLocked(key)
+	if !ok {
+		return Entry{}, false
+	}
+	prior := *e
+	e.LastAccess = time.Now()
+	e.Hit = true
+	return prior, true
+}
+
+func ttlExpireAt(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(ttl)
+}
+
+// setLocked stores a fresh entry at key and returns it. newCas, if
+// non-zero, is used verbatim as the entry's CAS token instead of one
+// generated by nextCas - the memcached meta protocol's ms ... E flag
+// lets a client pick it explicitly. Callers must hold s.mu.
+func (s *Store) setLocked(key string, value []byte, flags uint32, ttl time.Duration, newCas uint64) *Entry {
+	cas := newCas
+	if cas == 0 {
+		cas = s.nextCas()
+	}
+	e := &Entry{Value: value, Flags: flags, Cas: cas, ExpireAt: ttlExpireAt(ttl), LastAccess: time.Now()}
+	s.data[key] = e
+	return e
+}
+
+// Set unconditionally stores value at key, returning the new entry.
+func (s *Store) Set(key string, value []byte, flags uint32, ttl time.Duration) Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return *s.setLocked(key, value, flags, ttl, 0)
+}
+
+// SetWithCas is Set, but stores newCas verbatim as the new entry's CAS
+// token instead of generating one.
+func (s *Store) SetWithCas(key string, value []byte, flags uint32, ttl time.Duration, newCas uint64) Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return *s.setLocked(key, value, flags, ttl, newCas)
+}
+
+// SetCAS stores value at key only if the existing entry's CAS token
+// equals cas. It returns ErrNotFound if the key doesn't exist and
+// ErrCASMismatch if the token is stale.
+func (s *Store) SetCAS(key string, value []byte, flags uint32, ttl time.Duration, cas uint64) (Entry, error) {
+	return s.SetCASWithCas(key, value, flags, ttl, cas, 0)
+}
+
+// SetCASWithCas is SetCAS, but stores newCas verbatim as the new
+// entry's CAS token instead of generating one.
+func (s *Store) SetCASWithCas(key string, value []byte, flags uint32, ttl time.Duration, cas, newCas uint64) (Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.getLocked(key)
+	if !ok {
+		return Entry{}, ErrNotFound
+	}
+	if e.Cas != cas {
+		return Entry{}, ErrCASMismatch
+	}
+	return *s.setLocked(key, value, flags, ttl, newCas), nil
+}
+
+// Delete removes key, reporting whether it was present.
+func (s *Store) Delete(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.getLocked(key); !ok {
+		return false
+	}
+	delete(s.data, key)
+	return true
+}
+
+// Expire updates key's TTL in place, leaving its value untouched. A
+// non-positive ttl clears any existing expiry.
+func (s *Store) Expire(key string, ttl time.Duration) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.getLocked(key)
+	if !ok {
+		return false
+	}
+	e.ExpireAt = ttlExpireAt(ttl)
+	return true
+}
+
+// Incr applies delta to the integer value at key. If the key is absent
+// and autoViv is true, it is created with the given initial value and
+// ttl (delta is not applied to a freshly-vivified key, matching the
+// memcached/pogo_incr convention). Returns ErrNotFound if the key is
+// absent and autoViv is false.
+func (s *Store) Incr(key string, delta int64, autoViv bool, initial int64, ttl time.Duration) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.getLocked(key)
+	if !ok {
+		if !autoViv {
+			return 0, ErrNotFound
+		}
+		ne := &Entry{Value: []byte(strconv.FormatInt(initial, 10)), Cas: s.nextCas(), ExpireAt: ttlExpireAt(ttl), LastAccess: time.Now()}
+		s.data[key] = ne
+		return initial, nil
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(string(e.Value)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("store: value at %q is not an integer: %w", key, err)
+	}
+	n += delta
+	e.Value = []byte(strconv.FormatInt(n, 10))
+	e.Cas = s.nextCas()
+	return n, nil
+}
+
+// Keys returns every non-expired key with the given prefix, sorted, so
+// callers can page through a stable snapshot (e.g. for SCAN cursors).
+func (s *Store) Keys(prefix string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	out := make([]string, 0, len(s.data))
+	for k, e := range s.data {
+		if e.expired(now) {
+			continue
+		}
+		if strings.HasPrefix(k, prefix) {
+			out = append(out, k)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,158 @@
+// Package tracking implements the bookkeeping behind RESP3
+// server-assisted client-side caching (CLIENT TRACKING). It records,
+// per client, which keys that client has read since enabling tracking
+// (the reverse index making invalidation lookups cheap), plus the
+// BCAST-by-prefix registrations that don't require a prior read at all.
+//
+// Tracking only decides *whether* and *whom* to notify; delivering the
+// actual invalidation on the __redis__:invalidate channel is the RESP
+// frontend's job (see internal/resp), since that's where the pub/sub
+// broker and connection state already live.
+package tracking
+
+import (
+	"strings"
+	"sync"
+)
+
+// Tracker holds the client-id -> tracked-keys index (and its reverse,
+// key -> client-ids) used to decide which clients must be invalidated
+// when a key changes.
+type Tracker struct {
+	mu sync.Mutex
+
+	enabled       map[uint64]bool
+	redirect      map[uint64]uint64 // clientID -> target clientID for REDIRECT
+	bcastPrefixes map[uint64][]string
+
+	byClient map[uint64]map[string]bool
+	byKey    map[string]map[uint64]bool
+
+	// limit bounds the per-client tracked-key table. Once a client
+	// crosses it, its table is dropped entirely and the caller is
+	// expected to send a flush-all invalidation instead of individual
+	// key invalidations (see Track's return value).
+	limit int
+}
+
+// New returns a Tracker whose per-client tracked-key table is bounded at
+// limit entries (0 means unbounded).
+func New(limit int) *Tracker {
+	return &Tracker{
+		enabled:       make(map[uint64]bool),
+		redirect:      make(map[uint64]uint64),
+		bcastPrefixes: make(map[uint64][]string),
+		byClient:      make(map[uint64]map[string]bool),
+		byKey:         make(map[string]map[uint64]bool),
+		limit:         limit,
+	}
+}
+
+// Enable arms tracking for clientID. redirectTo, if non-zero, sends
+// invalidations to that client id instead. bcastPrefixes, if non-nil,
+// switches the client into BCAST mode: it's notified of writes under
+// any of those prefixes regardless of what it has read (an empty
+// prefix matches every key).
+func (t *Tracker) Enable(clientID, redirectTo uint64, bcastPrefixes []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.enabled[clientID] = true
+	if redirectTo != 0 {
+		t.redirect[clientID] = redirectTo
+	} else {
+		delete(t.redirect, clientID)
+	}
+	if bcastPrefixes != nil {
+		t.bcastPrefixes[clientID] = bcastPrefixes
+	} else {
+		delete(t.bcastPrefixes, clientID)
+	}
+}
+
+// Disable turns tracking off for clientID and drops its bookkeeping,
+// which is also what a connection close should call.
+func (t *Tracker) Disable(clientID uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.clearClientLocked(clientID)
+	delete(t.enabled, clientID)
+	delete(t.redirect, clientID)
+	delete(t.bcastPrefixes, clientID)
+}
+
+func (t *Tracker) clearClientLocked(clientID uint64) {
+	for key := range t.byClient[clientID] {
+		if m, ok := t.byKey[key]; ok {
+			delete(m, clientID)
+			if len(m) == 0 {
+				delete(t.byKey, key)
+			}
+		}
+	}
+	delete(t.byClient, clientID)
+}
+
+func (t *Tracker) targetLocked(clientID uint64) uint64 {
+	if to, ok := t.redirect[clientID]; ok {
+		return to
+	}
+	return clientID
+}
+
+// Track records that clientID has read key and wants to be invalidated
+// the next time it changes. It's a no-op for clients that aren't
+// tracking, or that are in BCAST mode (where reads don't matter). If
+// this read would push the client's table past its bound, the whole
+// table is dropped and full=true is returned so the caller can send a
+// flush-all invalidation instead.
+func (t *Tracker) Track(clientID uint64, key string) (full bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.enabled[clientID] {
+		return false
+	}
+	if _, bcast := t.bcastPrefixes[clientID]; bcast {
+		return false
+	}
+	if t.limit > 0 && len(t.byClient[clientID]) >= t.limit {
+		t.clearClientLocked(clientID)
+		return true
+	}
+	if t.byClient[clientID] == nil {
+		t.byClient[clientID] = make(map[string]bool)
+	}
+	t.byClient[clientID][key] = true
+	if t.byKey[key] == nil {
+		t.byKey[key] = make(map[uint64]bool)
+	}
+	t.byKey[key][clientID] = true
+	return false
+}
+
+// Invalidate reports which (already REDIRECT-resolved) client ids must
+// be notified that key changed: clients that previously Track()ed it,
+// plus any BCAST client whose registered prefix matches. The key's
+// reverse-index entries are consumed as part of the lookup.
+func (t *Tracker) Invalidate(key string) map[uint64]bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	targets := make(map[uint64]bool)
+	for clientID := range t.byKey[key] {
+		targets[t.targetLocked(clientID)] = true
+		if m := t.byClient[clientID]; m != nil {
+			delete(m, key)
+		}
+	}
+	delete(t.byKey, key)
+
+	for clientID, prefixes := range t.bcastPrefixes {
+		for _, p := range prefixes {
+			if p == "" || strings.HasPrefix(key, p) {
+				targets[t.targetLocked(clientID)] = true
+				break
+			}
+		}
+	}
+	return targets
+}
@@ -0,0 +1,102 @@
+package tests
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+// serverAddrs holds the listening addresses of the protocol frontends
+// exposed by a pogocache instance started with startServer.
+type serverAddrs struct {
+	Resp     string // RESP (Redis) frontend
+	Memcache string // Memcached frontend
+	Postgres string // PostgreSQL wire-protocol frontend
+}
+
+// startServer launches a throwaway pogocache instance with all three
+// frontends enabled and returns their listening addresses. The instance
+// is killed and its data directory removed when the test finishes.
+//
+// Tests are skipped rather than failed when the binary hasn't been
+// built, so `go test ./...` stays usable without a full build first.
+func startServer(t *testing.T, extraArgs ...string) serverAddrs {
+	t.Helper()
+
+	bin := os.Getenv("POGOCACHE_BIN")
+	if bin == "" {
+		bin = "../pogocache"
+	}
+	if _, err := os.Stat(bin); err != nil {
+		t.Skipf("pogocache binary not found at %s (build it first): %v", bin, err)
+	}
+
+	addrs := serverAddrs{
+		Resp:     fmt.Sprintf("127.0.0.1:%d", pickPort(t)),
+		Memcache: fmt.Sprintf("127.0.0.1:%d", pickPort(t)),
+		Postgres: fmt.Sprintf("127.0.0.1:%d", pickPort(t)),
+	}
+
+	args := append([]string{
+		"--dir", t.TempDir(),
+		"--port", portOf(t, addrs.Resp),
+		"--memcache-port", portOf(t, addrs.Memcache),
+		"--postgres-port", portOf(t, addrs.Postgres),
+	}, extraArgs...)
+
+	cmd := exec.Command(bin, args...)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("starting pogocache: %v", err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	waitForAddr(t, addrs.Resp)
+	waitForAddr(t, addrs.Memcache)
+	waitForAddr(t, addrs.Postgres)
+	return addrs
+}
+
+// pickPort reserves a free TCP port by briefly binding to it.
+func pickPort(t *testing.T) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("picking free port: %v", err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// portOf returns the port component of a host:port address.
+func portOf(t *testing.T, addr string) string {
+	t.Helper()
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatalf("splitting %q: %v", addr, err)
+	}
+	return port
+}
+
+// waitForAddr blocks until addr accepts TCP connections or the timeout
+// elapses.
+func waitForAddr(t *testing.T, addr string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		c, err := net.DialTimeout("tcp", addr, 100*time.Millisecond)
+		if err == nil {
+			c.Close()
+			return
+		}
+		time.Sleep(25 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %s to accept connections", addr)
+}
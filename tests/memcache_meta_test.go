@@ -0,0 +1,283 @@
+package tests
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// metaConn is a thin wrapper around a raw TCP connection to the
+// Memcached frontend for speaking the meta protocol (mg/ms/md/ma/me/mn),
+// which gomemcache doesn't emit.
+type metaConn struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dialMeta(t *testing.T, addr string) *metaConn {
+	t.Helper()
+	c, err := net.DialTimeout("tcp", addr, 2*time.Second)
+	if err != nil {
+		t.Fatalf("dialing memcache frontend: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return &metaConn{t: t, conn: c, r: bufio.NewReader(c)}
+}
+
+// send writes a meta command line, optionally followed by a datagram
+// value for ms, and returns the response lines up to and including the
+// terminating status line (everything starting with a two-letter code).
+func (m *metaConn) send(line string, value []byte) []string {
+	m.t.Helper()
+	m.conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := fmt.Fprintf(m.conn, "%s\r\n", line); err != nil {
+		m.t.Fatalf("writing %q: %v", line, err)
+	}
+	if value != nil {
+		if _, err := m.conn.Write(append(value, '\r', '\n')); err != nil {
+			m.t.Fatalf("writing value for %q: %v", line, err)
+		}
+	}
+
+	status, err := m.r.ReadString('\n')
+	if err != nil {
+		m.t.Fatalf("reading status for %q: %v", line, err)
+	}
+	lines := []string{status}
+
+	// A successful "VA <len> <flags>..." reply carries a data block.
+	if len(status) >= 2 && status[:2] == "VA" {
+		var n int
+		fmt.Sscanf(status[3:], "%d", &n)
+		data := make([]byte, n+2) // +2 for trailing \r\n
+		if _, err := m.readFull(data); err != nil {
+			m.t.Fatalf("reading data block for %q: %v", line, err)
+		}
+		lines = append(lines, string(data[:n]))
+	}
+	return lines
+}
+
+func (m *metaConn) readFull(buf []byte) (int, error) {
+	total := 0
+	for total < len(buf) {
+		n, err := m.r.Read(buf[total:])
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// statusFlags splits a status line's trailing "<code> <flag><value>..."
+// tokens into a map keyed by flag letter, for asserting on specific
+// response flags (e.g. "HD c42 h1" -> {'c': "42", 'h': "1"}).
+func statusFlags(status string) map[byte]string {
+	fields := strings.Fields(status)
+	m := make(map[byte]string, len(fields)-1)
+	for _, tok := range fields[1:] {
+		m[tok[0]] = tok[1:]
+	}
+	return m
+}
+
+// TestMetaSetGetDelete exercises the basic ms/mg/md round-trip with the
+// opaque (O) and flags (F) tokens.
+func TestMetaSetGetDelete(t *testing.T) {
+	addrs := startServer(t)
+	m := dialMeta(t, addrs.Memcache)
+
+	resp := m.send("ms greeting 5 F7 Oabc", []byte("howdy"))
+	if resp[0][:2] != "HD" {
+		t.Fatalf("ms: expected HD, got %q", resp[0])
+	}
+
+	resp = m.send("mg greeting v f Oabc", nil)
+	if resp[0][:2] != "VA" {
+		t.Fatalf("mg: expected VA, got %q", resp[0])
+	}
+	if resp[1] != "howdy" {
+		t.Fatalf("mg: expected value %q, got %q", "howdy", resp[1])
+	}
+
+	resp = m.send("md greeting", nil)
+	if resp[0][:2] != "HD" {
+		t.Fatalf("md: expected HD, got %q", resp[0])
+	}
+
+	resp = m.send("mg greeting v", nil)
+	if resp[0][:2] != "EN" {
+		t.Fatalf("mg after md: expected EN (miss), got %q", resp[0])
+	}
+}
+
+// TestMetaCASConditionalWrite checks that ms honours the CAS token (C)
+// so a write against a stale CAS value is rejected.
+func TestMetaCASConditionalWrite(t *testing.T) {
+	addrs := startServer(t)
+	m := dialMeta(t, addrs.Memcache)
+
+	resp := m.send("ms widget 3 c", []byte("one"))
+	if resp[0][:2] != "HD" {
+		t.Fatalf("ms: expected HD, got %q", resp[0])
+	}
+
+	resp = m.send("mg widget c v", nil)
+	if resp[0][:2] != "VA" {
+		t.Fatalf("mg: expected VA, got %q", resp[0])
+	}
+
+	resp = m.send("ms widget 3 C999999", []byte("two"))
+	if resp[0][:2] != "EX" {
+		t.Fatalf("ms with stale CAS: expected EX, got %q", resp[0])
+	}
+
+	resp = m.send("mg widget v", nil)
+	if resp[1] != "one" {
+		t.Fatalf("value should be unchanged after rejected CAS write, got %q", resp[1])
+	}
+}
+
+// TestMetaTTLAndAutoVivify checks the T (TTL) flag on ms and the N
+// (auto-vivify) flag on ma for arithmetic on a missing key.
+func TestMetaTTLAndAutoVivify(t *testing.T) {
+	addrs := startServer(t)
+	m := dialMeta(t, addrs.Memcache)
+
+	resp := m.send("ms counter 1 T100", []byte("5"))
+	if resp[0][:2] != "HD" {
+		t.Fatalf("ms: expected HD, got %q", resp[0])
+	}
+
+	resp = m.send("ma counter v", nil)
+	if resp[0][:2] != "VA" || resp[1] != "6" {
+		t.Fatalf("ma: expected incremented value 6, got %q / %q", resp[0], resp[1])
+	}
+
+	resp = m.send("ma fresh-counter N60 J42 v", nil)
+	if resp[0][:2] != "VA" || resp[1] != "42" {
+		t.Fatalf("ma with auto-vivify: expected initial value 42, got %q / %q", resp[0], resp[1])
+	}
+}
+
+// TestMetaQuietSuppressesHit checks that the q flag suppresses the
+// normal HD/VA response on a hit. Per the meta protocol, a quiet
+// pipeline is terminated with "mn" so the client can tell a silent hit
+// from a connection that's still processing.
+func TestMetaQuietSuppressesHit(t *testing.T) {
+	addrs := startServer(t)
+	m := dialMeta(t, addrs.Memcache)
+
+	m.send("ms quietkey 2", []byte("ok"))
+
+	m.conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := fmt.Fprint(m.conn, "mg quietkey q\r\nmn\r\n"); err != nil {
+		t.Fatalf("writing quiet pipeline: %v", err)
+	}
+	status, err := m.r.ReadString('\n')
+	if err != nil {
+		t.Fatalf("reading mn terminator: %v", err)
+	}
+	if status[:2] != "MN" {
+		t.Fatalf("mn: expected MN terminator with no intervening hit reply, got %q", status)
+	}
+}
+
+// TestMetaHitFlagTracksFirstAccess checks that mg's h flag reports
+// whether the item was hit before this particular access: 0 on the
+// first read after a write, 1 on every read after that.
+func TestMetaHitFlagTracksFirstAccess(t *testing.T) {
+	addrs := startServer(t)
+	m := dialMeta(t, addrs.Memcache)
+
+	resp := m.send("ms clicked 2", []byte("ok"))
+	if resp[0][:2] != "HD" {
+		t.Fatalf("ms: expected HD, got %q", resp[0])
+	}
+
+	resp = m.send("mg clicked v h", nil)
+	if resp[0][:2] != "VA" {
+		t.Fatalf("mg: expected VA, got %q", resp[0])
+	}
+	if got := statusFlags(resp[0])['h']; got != "0" {
+		t.Fatalf("mg h on first access: expected h0, got h%s", got)
+	}
+
+	resp = m.send("mg clicked v h", nil)
+	if got := statusFlags(resp[0])['h']; got != "1" {
+		t.Fatalf("mg h on second access: expected h1, got h%s", got)
+	}
+}
+
+// TestMetaLastAccessFlag checks that mg's l flag reports (approximately)
+// zero seconds since an item freshly written was last accessed.
+func TestMetaLastAccessFlag(t *testing.T) {
+	addrs := startServer(t)
+	m := dialMeta(t, addrs.Memcache)
+
+	m.send("ms recent 2", []byte("ok"))
+	resp := m.send("mg recent v l", nil)
+	if resp[0][:2] != "VA" {
+		t.Fatalf("mg: expected VA, got %q", resp[0])
+	}
+	if got := statusFlags(resp[0])['l']; got != "0" {
+		t.Fatalf("mg l just after write: expected l0, got l%s", got)
+	}
+}
+
+// TestMetaExplicitCAS checks that ms's E flag lets a client pick the
+// new CAS token instead of one generated by the store.
+func TestMetaExplicitCAS(t *testing.T) {
+	addrs := startServer(t)
+	m := dialMeta(t, addrs.Memcache)
+
+	resp := m.send("ms pinned 3 E12345 c", []byte("one"))
+	if resp[0][:2] != "HD" {
+		t.Fatalf("ms: expected HD, got %q", resp[0])
+	}
+	if got := statusFlags(resp[0])['c']; got != "12345" {
+		t.Fatalf("ms E: expected the explicit CAS 12345 echoed back, got c%s", got)
+	}
+
+	resp = m.send("mg pinned c v", nil)
+	if got := statusFlags(resp[0])['c']; got != "12345" {
+		t.Fatalf("mg after ms E: expected CAS 12345, got c%s", got)
+	}
+}
+
+// TestMetaBase64Key checks that the b flag decodes the key token as
+// base64 before touching the store, and that a malformed one is
+// rejected rather than silently treated as a literal key.
+func TestMetaBase64Key(t *testing.T) {
+	addrs := startServer(t)
+	m := dialMeta(t, addrs.Memcache)
+
+	encoded := base64.StdEncoding.EncodeToString([]byte("real-key"))
+
+	resp := m.send(fmt.Sprintf("ms %s 5 b", encoded), []byte("howdy"))
+	if resp[0][:2] != "HD" {
+		t.Fatalf("ms b: expected HD, got %q", resp[0])
+	}
+
+	// The literal encoded token must not have been used as the key.
+	resp = m.send(fmt.Sprintf("mg %s v", encoded), nil)
+	if resp[0][:2] != "EN" {
+		t.Fatalf("mg on the literal base64 token: expected EN (miss), got %q", resp[0])
+	}
+
+	resp = m.send(fmt.Sprintf("mg %s v b", encoded), nil)
+	if resp[0][:2] != "VA" || resp[1] != "howdy" {
+		t.Fatalf("mg b: expected VA howdy, got %q / %q", resp[0], resp[1])
+	}
+
+	resp = m.send("mg not-valid-base64!! b", nil)
+	if resp[0][:12] != "CLIENT_ERROR" {
+		t.Fatalf("mg with malformed base64 key: expected CLIENT_ERROR, got %q", resp[0])
+	}
+}
@@ -0,0 +1,473 @@
+package tests
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// pgConnString builds a connection string for the Postgres frontend
+// started by startServer.
+func pgConnString(t *testing.T, addrs serverAddrs) string {
+	return fmt.Sprintf("postgres://127.0.0.1:%s/?sslmode=disable", portOf(t, addrs.Postgres))
+}
+
+// TestExtendedQueryPreparedGetSet drives conn.Prepare against the
+// pogo_get/pogo_set functions, forcing the extended-query flow
+// (Parse/Bind/Describe/Execute/Sync) with numeric parameters instead of
+// string interpolation.
+func TestExtendedQueryPreparedGetSet(t *testing.T) {
+	addrs := startServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	conn, err := pgx.Connect(ctx, pgConnString(t, addrs))
+	if err != nil {
+		t.Fatalf("connecting via postgres frontend: %v", err)
+	}
+	defer conn.Close(ctx)
+
+	if _, err := conn.Prepare(ctx, "set_stmt", "CALL pogo_set($1, $2, $3)"); err != nil {
+		t.Fatalf("preparing pogo_set: %v", err)
+	}
+	if _, err := conn.Prepare(ctx, "get_stmt", "SELECT pogo_get($1)"); err != nil {
+		t.Fatalf("preparing pogo_get: %v", err)
+	}
+
+	if _, err := conn.Exec(ctx, "set_stmt", "greeting", "howdy", int64(0)); err != nil {
+		t.Fatalf("executing pogo_set: %v", err)
+	}
+
+	var value string
+	if err := conn.QueryRow(ctx, "get_stmt", "greeting").Scan(&value); err != nil {
+		t.Fatalf("executing pogo_get: %v", err)
+	}
+	if value != "howdy" {
+		t.Fatalf("expected %q, got %q", "howdy", value)
+	}
+}
+
+// TestExtendedQueryBinaryParameters checks that int8 and timestamptz
+// parameters round-trip correctly when bound in the binary format, as
+// pgx prefers for those types once a statement is prepared.
+func TestExtendedQueryBinaryParameters(t *testing.T) {
+	addrs := startServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	conn, err := pgx.Connect(ctx, pgConnString(t, addrs))
+	if err != nil {
+		t.Fatalf("connecting via postgres frontend: %v", err)
+	}
+	defer conn.Close(ctx)
+
+	if _, err := conn.Prepare(ctx, "incr_stmt", "SELECT pogo_incr($1, $2)"); err != nil {
+		t.Fatalf("preparing pogo_incr: %v", err)
+	}
+
+	var total int64
+	if err := conn.QueryRow(ctx, "incr_stmt", "counter", int64(5)).Scan(&total); err != nil {
+		t.Fatalf("executing pogo_incr: %v", err)
+	}
+	if total != 5 {
+		t.Fatalf("expected 5, got %d", total)
+	}
+	if err := conn.QueryRow(ctx, "incr_stmt", "counter", int64(3)).Scan(&total); err != nil {
+		t.Fatalf("executing pogo_incr: %v", err)
+	}
+	if total != 8 {
+		t.Fatalf("expected 8, got %d", total)
+	}
+
+	if _, err := conn.Prepare(ctx, "expire_stmt", "CALL pogo_expire($1, $2)"); err != nil {
+		t.Fatalf("preparing pogo_expire: %v", err)
+	}
+	if _, err := conn.Exec(ctx, "expire_stmt", "counter", time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("executing pogo_expire with timestamptz parameter: %v", err)
+	}
+}
+
+// TestExtendedQueryScanPortalSuspension checks that a SELECT pogo_scan($1)
+// cursor is paged via repeated Execute calls against a suspended portal,
+// rather than returning the whole keyspace from a single Execute.
+func TestExtendedQueryScanPortalSuspension(t *testing.T) {
+	addrs := startServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	conn, err := pgx.Connect(ctx, pgConnString(t, addrs))
+	if err != nil {
+		t.Fatalf("connecting via postgres frontend: %v", err)
+	}
+	defer conn.Close(ctx)
+
+	for i := 0; i < 5; i++ {
+		if _, err := conn.Exec(ctx, "CALL pogo_set($1, $2, $3)", fmt.Sprintf("scan-key-%d", i), "v", int64(0)); err != nil {
+			t.Fatalf("seeding scan-key-%d: %v", i, err)
+		}
+	}
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		t.Fatalf("BEGIN: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, "SELECT pogo_scan($1)", "scan-key-")
+	if err != nil {
+		t.Fatalf("SELECT pogo_scan: %v", err)
+	}
+	defer rows.Close()
+
+	seen := 0
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			t.Fatalf("scanning row: %v", err)
+		}
+		seen++
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("iterating scan rows: %v", err)
+	}
+	if seen != 5 {
+		t.Fatalf("expected 5 rows from pogo_scan, got %d", seen)
+	}
+}
+
+// TestExtendedQueryBatchedPipeline exercises pgx's pipeline mode, which
+// issues several Parse/Bind/Describe/Execute sequences before a single
+// Sync, checking the frontend replies to each in request order.
+func TestExtendedQueryBatchedPipeline(t *testing.T) {
+	addrs := startServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	pool, err := pgxpool.New(ctx, pgConnString(t, addrs))
+	if err != nil {
+		t.Fatalf("connecting via postgres frontend: %v", err)
+	}
+	defer pool.Close()
+
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		t.Fatalf("acquiring connection: %v", err)
+	}
+	defer conn.Release()
+
+	batch := &pgx.Batch{}
+	batch.Queue("CALL pogo_set($1, $2, $3)", "batch-a", "1", int64(0))
+	batch.Queue("CALL pogo_set($1, $2, $3)", "batch-b", "2", int64(0))
+	batch.Queue("SELECT pogo_get($1)", "batch-a")
+	batch.Queue("SELECT pogo_get($1)", "batch-b")
+
+	br := conn.SendBatch(ctx, batch)
+	defer br.Close()
+
+	if _, err := br.Exec(); err != nil {
+		t.Fatalf("batched pogo_set(batch-a): %v", err)
+	}
+	if _, err := br.Exec(); err != nil {
+		t.Fatalf("batched pogo_set(batch-b): %v", err)
+	}
+
+	var a, b string
+	if err := br.QueryRow().Scan(&a); err != nil {
+		t.Fatalf("batched pogo_get(batch-a): %v", err)
+	}
+	if err := br.QueryRow().Scan(&b); err != nil {
+		t.Fatalf("batched pogo_get(batch-b): %v", err)
+	}
+	if a != "1" || b != "2" {
+		t.Fatalf("unexpected batched results: a=%q b=%q", a, b)
+	}
+}
+
+// rawPGConn speaks just enough of the wire protocol by hand to construct
+// malformed Parse/Bind sequences pgx itself would never produce, such as
+// a parameter whose declared OID disagrees with its bound format.
+type rawPGConn struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dialRawPostgres(t *testing.T, addr string) *rawPGConn {
+	t.Helper()
+	c, err := net.DialTimeout("tcp", addr, 2*time.Second)
+	if err != nil {
+		t.Fatalf("dialing postgres frontend: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	return &rawPGConn{t: t, conn: c, r: bufio.NewReader(c)}
+}
+
+func (c *rawPGConn) writeMessage(typ byte, body []byte) {
+	c.t.Helper()
+	var msg bytes.Buffer
+	msg.WriteByte(typ)
+	binary.Write(&msg, binary.BigEndian, int32(len(body)+4))
+	msg.Write(body)
+	if _, err := c.conn.Write(msg.Bytes()); err != nil {
+		c.t.Fatalf("writing %q message: %v", typ, err)
+	}
+}
+
+func (c *rawPGConn) readMessage() (byte, []byte) {
+	c.t.Helper()
+	typ, err := c.r.ReadByte()
+	if err != nil {
+		c.t.Fatalf("reading message type: %v", err)
+	}
+	var lenBuf [4]byte
+	if _, err := io.ReadFull(c.r, lenBuf[:]); err != nil {
+		c.t.Fatalf("reading message length: %v", err)
+	}
+	n := int(binary.BigEndian.Uint32(lenBuf[:])) - 4
+	payload := make([]byte, n)
+	if n > 0 {
+		if _, err := io.ReadFull(c.r, payload); err != nil {
+			c.t.Fatalf("reading message payload: %v", err)
+		}
+	}
+	return typ, payload
+}
+
+// readUntilReady drains messages through the next ReadyForQuery, reporting
+// whether an ErrorResponse was seen along the way.
+func (c *rawPGConn) readUntilReady() (sawError bool) {
+	c.t.Helper()
+	for {
+		typ, _ := c.readMessage()
+		switch typ {
+		case 'Z':
+			return sawError
+		case 'E':
+			sawError = true
+		}
+	}
+}
+
+func (c *rawPGConn) startup() {
+	c.t.Helper()
+	var body bytes.Buffer
+	binary.Write(&body, binary.BigEndian, int32(196608)) // protocol version 3.0
+	body.WriteString("user\x00postgres\x00\x00")
+	var msg bytes.Buffer
+	binary.Write(&msg, binary.BigEndian, int32(body.Len()+4))
+	msg.Write(body.Bytes())
+	if _, err := c.conn.Write(msg.Bytes()); err != nil {
+		c.t.Fatalf("writing startup message: %v", err)
+	}
+	c.readUntilReady()
+}
+
+func (c *rawPGConn) parse(name, query string, paramOIDs []int32) {
+	c.t.Helper()
+	var body bytes.Buffer
+	body.WriteString(name)
+	body.WriteByte(0)
+	body.WriteString(query)
+	body.WriteByte(0)
+	binary.Write(&body, binary.BigEndian, int16(len(paramOIDs)))
+	for _, oid := range paramOIDs {
+		binary.Write(&body, binary.BigEndian, oid)
+	}
+	c.writeMessage('P', body.Bytes())
+}
+
+func (c *rawPGConn) bind(portal, stmt string, paramFormats []int16, params [][]byte, resultFormats []int16) {
+	c.t.Helper()
+	var body bytes.Buffer
+	body.WriteString(portal)
+	body.WriteByte(0)
+	body.WriteString(stmt)
+	body.WriteByte(0)
+	binary.Write(&body, binary.BigEndian, int16(len(paramFormats)))
+	for _, f := range paramFormats {
+		binary.Write(&body, binary.BigEndian, f)
+	}
+	binary.Write(&body, binary.BigEndian, int16(len(params)))
+	for _, p := range params {
+		if p == nil {
+			binary.Write(&body, binary.BigEndian, int32(-1))
+			continue
+		}
+		binary.Write(&body, binary.BigEndian, int32(len(p)))
+		body.Write(p)
+	}
+	binary.Write(&body, binary.BigEndian, int16(len(resultFormats)))
+	for _, f := range resultFormats {
+		binary.Write(&body, binary.BigEndian, f)
+	}
+	c.writeMessage('B', body.Bytes())
+}
+
+func (c *rawPGConn) execute(portal string, maxRows int32) {
+	c.t.Helper()
+	var body bytes.Buffer
+	body.WriteString(portal)
+	body.WriteByte(0)
+	binary.Write(&body, binary.BigEndian, maxRows)
+	c.writeMessage('E', body.Bytes())
+}
+
+func (c *rawPGConn) sync() {
+	c.writeMessage('S', nil)
+}
+
+func encodeInt64BE(n int64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(n))
+	return buf[:]
+}
+
+// TestExtendedQueryMismatchedParamTypeDoesNotCrash declares $1 as int8
+// for pogo_get's key (which is really text), then binds a binary int64 -
+// runPortal's asString(0) used to type-assert that straight into a
+// panic, which an unrecovered goroutine would turn into a process crash
+// taking down every other connection. The frontend must instead reply
+// with an ErrorResponse and keep serving both this connection and new
+// ones.
+func TestExtendedQueryMismatchedParamTypeDoesNotCrash(t *testing.T) {
+	addrs := startServer(t)
+	raw := dialRawPostgres(t, addrs.Postgres)
+	raw.startup()
+
+	raw.parse("bad_stmt", "SELECT pogo_get($1)", []int32{20})
+	raw.bind("", "bad_stmt", []int16{1}, [][]byte{encodeInt64BE(5)}, nil)
+	raw.execute("", 0)
+	raw.sync()
+
+	if sawError := raw.readUntilReady(); !sawError {
+		t.Fatalf("expected an ErrorResponse for the mismatched parameter type")
+	}
+
+	// The same connection, and the server as a whole, must still work.
+	raw.parse("get_stmt", "SELECT pogo_get($1)", []int32{25})
+	raw.bind("", "get_stmt", nil, [][]byte{[]byte("missing-key")}, nil)
+	raw.execute("", 0)
+	raw.sync()
+	if sawError := raw.readUntilReady(); sawError {
+		t.Fatalf("well-typed query after the bad one should not error")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := pgx.Connect(ctx, pgConnString(t, addrs))
+	if err != nil {
+		t.Fatalf("connecting a fresh client after the bad one: %v", err)
+	}
+	defer conn.Close(ctx)
+	if _, err := conn.Exec(ctx, "CALL pogo_set($1, $2, $3)", "survivor", "ok", int64(0)); err != nil {
+		t.Fatalf("server should still be accepting new connections: %v", err)
+	}
+}
+
+// simpleExec runs sql via the simple query protocol (pgconn.Exec sends
+// a single 'Q' message, unlike pgx.Conn.Exec/Query which default to
+// Parse/Bind even without pgx-level parameters), returning the result
+// rows as strings.
+func simpleExec(t *testing.T, pg *pgconn.PgConn, ctx context.Context, sql string) ([][]string, pgconn.CommandTag, error) {
+	t.Helper()
+	results, err := pg.Exec(ctx, sql).ReadAll()
+	if err != nil {
+		return nil, pgconn.CommandTag{}, err
+	}
+	if len(results) != 1 {
+		t.Fatalf("expected exactly one result for %q, got %d", sql, len(results))
+	}
+	if results[0].Err != nil {
+		return nil, pgconn.CommandTag{}, results[0].Err
+	}
+	rows := make([][]string, len(results[0].Rows))
+	for i, row := range results[0].Rows {
+		cols := make([]string, len(row))
+		for j, col := range row {
+			cols[j] = string(col)
+		}
+		rows[i] = cols
+	}
+	return rows, results[0].CommandTag, nil
+}
+
+// TestSimpleQueryLiteralArguments checks that a pogo_* call written
+// with literal arguments rather than through Parse/Bind (e.g.
+// "SELECT pogo_get('somekey')") actually operates on those arguments,
+// rather than the simple-query path silently treating the call as if
+// it had been issued with no arguments at all.
+func TestSimpleQueryLiteralArguments(t *testing.T) {
+	addrs := startServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	conn, err := pgx.Connect(ctx, pgConnString(t, addrs))
+	if err != nil {
+		t.Fatalf("connecting via postgres frontend: %v", err)
+	}
+	defer conn.Close(ctx)
+	pg := conn.PgConn()
+
+	if _, _, err := simpleExec(t, pg, ctx, "CALL pogo_set('literal-key', 'literal-value', 0)"); err != nil {
+		t.Fatalf("literal pogo_set: %v", err)
+	}
+
+	rows, _, err := simpleExec(t, pg, ctx, "SELECT pogo_get('literal-key')")
+	if err != nil {
+		t.Fatalf("literal pogo_get: %v", err)
+	}
+	if len(rows) != 1 || rows[0][0] != "literal-value" {
+		t.Fatalf("expected [[literal-value]], got %v", rows)
+	}
+
+	// A second key, so the two literal calls above can't have passed
+	// by coincidentally both resolving to the same empty key.
+	if _, _, err := simpleExec(t, pg, ctx, "CALL pogo_set('other-key', 'other-value', 0)"); err != nil {
+		t.Fatalf("literal pogo_set (other-key): %v", err)
+	}
+	rows, _, err = simpleExec(t, pg, ctx, "SELECT pogo_get('other-key')")
+	if err != nil {
+		t.Fatalf("literal pogo_get (other-key): %v", err)
+	}
+	if len(rows) != 1 || rows[0][0] != "other-value" {
+		t.Fatalf("expected [[other-value]], got %v", rows)
+	}
+}
+
+// TestSimpleQueryMalformedLiteralArgumentErrors checks that an
+// unsupported literal argument shape in a simple-query pogo_* call
+// (here, an unterminated string literal) produces an ErrorResponse
+// rather than silently running the statement against an empty/zero
+// argument.
+func TestSimpleQueryMalformedLiteralArgumentErrors(t *testing.T) {
+	addrs := startServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	conn, err := pgx.Connect(ctx, pgConnString(t, addrs))
+	if err != nil {
+		t.Fatalf("connecting via postgres frontend: %v", err)
+	}
+	defer conn.Close(ctx)
+	pg := conn.PgConn()
+
+	if _, _, err := simpleExec(t, pg, ctx, "SELECT pogo_get('unterminated)"); err == nil {
+		t.Fatalf("expected an error for an unterminated string literal, got none")
+	}
+
+	// The connection must still be usable afterwards.
+	if _, err := conn.Exec(ctx, "CALL pogo_set($1, $2, $3)", "survivor", "ok", int64(0)); err != nil {
+		t.Fatalf("server should still be accepting commands: %v", err)
+	}
+}
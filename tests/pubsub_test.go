@@ -0,0 +1,165 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// connectWithNotify opens a Postgres frontend connection whose
+// LISTEN/NOTIFY notifications are delivered on the returned channel, via
+// pgconn's OnNotification callback.
+func connectWithNotify(t *testing.T, connString string) (*pgconn.PgConn, <-chan *pgconn.Notification) {
+	t.Helper()
+	cfg, err := pgconn.ParseConfig(connString)
+	if err != nil {
+		t.Fatalf("parsing connection string: %v", err)
+	}
+	notifyCh := make(chan *pgconn.Notification, 4)
+	cfg.OnNotification = func(_ *pgconn.PgConn, n *pgconn.Notification) {
+		notifyCh <- n
+	}
+	pg, err := pgconn.ConnectConfig(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("connecting via postgres frontend: %v", err)
+	}
+	return pg, notifyCh
+}
+
+// TestListenNotifyBridgesToRedisPubSub checks that a NOTIFY published by a
+// Redis client (via PUBLISH) is delivered to a Postgres client that issued
+// LISTEN on the same channel, confirming both frontends share one
+// pub-sub broker.
+func TestListenNotifyBridgesToRedisPubSub(t *testing.T) {
+	addrs := startServer(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	pg, notifyCh := connectWithNotify(t, fmt.Sprintf("postgres://127.0.0.1:%s/?sslmode=disable", portOf(t, addrs.Postgres)))
+	defer pg.Close(ctx)
+
+	if _, err := pg.Exec(ctx, "LISTEN news").ReadAll(); err != nil {
+		t.Fatalf("LISTEN news: %v", err)
+	}
+
+	rc, err := redis.Dial("tcp", addrs.Resp)
+	if err != nil {
+		t.Fatalf("dialing redis frontend: %v", err)
+	}
+	defer rc.Close()
+
+	if _, err := rc.Do("PUBLISH", "news", "hello from redis"); err != nil {
+		t.Fatalf("PUBLISH: %v", err)
+	}
+
+	if err := pg.WaitForNotification(ctx); err != nil {
+		t.Fatalf("waiting for bridged notification: %v", err)
+	}
+
+	select {
+	case notice := <-notifyCh:
+		if notice.Channel != "news" || notice.Payload != "hello from redis" {
+			t.Fatalf("unexpected notification: %+v", notice)
+		}
+	default:
+		t.Fatal("WaitForNotification returned but no notification was queued")
+	}
+}
+
+// TestNotifyBridgesToRedisSubscribe exercises the reverse direction of
+// TestListenNotifyBridgesToRedisPubSub: a NOTIFY issued over the Postgres
+// frontend must arrive as a pub-sub message on a Redis SUBSCRIBE
+// connection.
+func TestNotifyBridgesToRedisSubscribe(t *testing.T) {
+	addrs := startServer(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	subConn, err := redis.Dial("tcp", addrs.Resp)
+	if err != nil {
+		t.Fatalf("dialing redis frontend: %v", err)
+	}
+	psc := redis.PubSubConn{Conn: subConn}
+	defer psc.Close()
+
+	if err := psc.Subscribe("alerts"); err != nil {
+		t.Fatalf("SUBSCRIBE: %v", err)
+	}
+
+	msgCh := make(chan redis.Message, 1)
+	errCh := make(chan error, 1)
+	go func() {
+		for {
+			switch v := psc.Receive().(type) {
+			case redis.Subscription:
+				continue
+			case redis.Message:
+				msgCh <- v
+				return
+			case error:
+				errCh <- v
+				return
+			}
+		}
+	}()
+
+	pg, err := pgconn.Connect(ctx, fmt.Sprintf("postgres://127.0.0.1:%s/?sslmode=disable", portOf(t, addrs.Postgres)))
+	if err != nil {
+		t.Fatalf("connecting via postgres frontend: %v", err)
+	}
+	defer pg.Close(ctx)
+
+	if _, err := pg.Exec(ctx, "NOTIFY alerts, 'hello from postgres'").ReadAll(); err != nil {
+		t.Fatalf("NOTIFY: %v", err)
+	}
+
+	select {
+	case msg := <-msgCh:
+		if msg.Channel != "alerts" || string(msg.Data) != "hello from postgres" {
+			t.Fatalf("unexpected redis message: %+v", msg)
+		}
+	case err := <-errCh:
+		t.Fatalf("pub-sub receive error: %v", err)
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for bridged NOTIFY to arrive via redis SUBSCRIBE")
+	}
+}
+
+// TestUnlistenStopsDelivery checks that UNLISTEN actually detaches the
+// Postgres connection from the channel, so a later PUBLISH is not
+// delivered.
+func TestUnlistenStopsDelivery(t *testing.T) {
+	addrs := startServer(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	pg, _ := connectWithNotify(t, fmt.Sprintf("postgres://127.0.0.1:%s/?sslmode=disable", portOf(t, addrs.Postgres)))
+	defer pg.Close(context.Background())
+
+	if _, err := pg.Exec(context.Background(), "LISTEN quiet").ReadAll(); err != nil {
+		t.Fatalf("LISTEN quiet: %v", err)
+	}
+	if _, err := pg.Exec(context.Background(), "UNLISTEN quiet").ReadAll(); err != nil {
+		t.Fatalf("UNLISTEN quiet: %v", err)
+	}
+
+	rc, err := redis.Dial("tcp", addrs.Resp)
+	if err != nil {
+		t.Fatalf("dialing redis frontend: %v", err)
+	}
+	defer rc.Close()
+	if _, err := rc.Do("PUBLISH", "quiet", "should not arrive"); err != nil {
+		t.Fatalf("PUBLISH: %v", err)
+	}
+
+	if err := pg.WaitForNotification(ctx); err == nil {
+		t.Fatal("expected no notification after UNLISTEN, got one")
+	}
+}
@@ -0,0 +1,181 @@
+package tests
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rawRESPConn is a minimal hand-rolled RESP2/RESP3 client used where the
+// go-redis high-level API can't express what a test needs: go-redis's
+// PubSub.Subscribe always dials a brand-new, separate physical
+// connection with its own server-assigned CLIENT ID that's never
+// exposed to the caller, so there's no way to learn that ID for a
+// CLIENT TRACKING ... REDIRECT target, or to observe an unsolicited
+// RESP3 push arriving on the same connection that enabled tracking.
+type rawRESPConn struct {
+	t    *testing.T
+	conn net.Conn
+	br   *bufio.Reader
+}
+
+// dialRawRESP connects to addr and leaves the connection in RESP2 mode;
+// call hello3 to switch to RESP3.
+func dialRawRESP(t *testing.T, addr string) *rawRESPConn {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dialing %s: %v", addr, err)
+	}
+	return &rawRESPConn{t: t, conn: conn, br: bufio.NewReader(conn)}
+}
+
+func (r *rawRESPConn) Close() error {
+	return r.conn.Close()
+}
+
+func (r *rawRESPConn) sendCommand(args ...string) {
+	r.t.Helper()
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if _, err := io.WriteString(r.conn, b.String()); err != nil {
+		r.t.Fatalf("writing command: %v", err)
+	}
+}
+
+// readValue reads one RESP reply, decoding simple strings, errors,
+// integers, bulk strings, and arrays/pushes/maps into nested
+// []interface{} (nil elements represent RESP3 nulls).
+func (r *rawRESPConn) readValue() interface{} {
+	r.t.Helper()
+	v, err := r.readValueErr()
+	if err != nil {
+		r.t.Fatalf("reading reply: %v", err)
+	}
+	return v
+}
+
+func (r *rawRESPConn) readValueErr() (interface{}, error) {
+	line, err := r.br.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return nil, fmt.Errorf("empty reply line")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, fmt.Errorf("server error: %s", line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '_':
+		return nil, nil
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r.br, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*', '>':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		vals := make([]interface{}, n)
+		for i := range vals {
+			if vals[i], err = r.readValueErr(); err != nil {
+				return nil, err
+			}
+		}
+		return vals, nil
+	case '%':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		vals := make([]interface{}, n*2)
+		for i := range vals {
+			if vals[i], err = r.readValueErr(); err != nil {
+				return nil, err
+			}
+		}
+		return vals, nil
+	default:
+		return nil, fmt.Errorf("unexpected reply type %q", line)
+	}
+}
+
+// hello3 negotiates RESP3, which is required for unsolicited pushes
+// (tracking invalidations, pub/sub messages) to be readable on a
+// connection that's also being used for regular commands.
+func (r *rawRESPConn) hello3() {
+	r.t.Helper()
+	r.sendCommand("HELLO", "3")
+	r.readValue()
+}
+
+// do sends a command and returns its reply.
+func (r *rawRESPConn) do(args ...string) interface{} {
+	r.t.Helper()
+	r.sendCommand(args...)
+	return r.readValue()
+}
+
+// clientID issues CLIENT ID and returns the result.
+func (r *rawRESPConn) clientID() int64 {
+	r.t.Helper()
+	v, ok := r.do("CLIENT", "ID").(int64)
+	if !ok {
+		r.t.Fatalf("CLIENT ID: unexpected reply type %T", v)
+	}
+	return v
+}
+
+// readPush reads the next frame and expects it to be a 3-element
+// "message" push/array (as both pub/sub messages and tracking
+// invalidations are shaped), returning its channel and payload.
+func (r *rawRESPConn) readPush() (channel, payload string) {
+	r.t.Helper()
+	v := r.readValue()
+	return parseMessagePush(r.t, v)
+}
+
+// tryReadPush is readPush with a deadline, for asserting that nothing
+// arrives within timeout rather than blocking forever.
+func (r *rawRESPConn) tryReadPush(timeout time.Duration) (channel, payload string, ok bool) {
+	r.t.Helper()
+	r.conn.SetReadDeadline(time.Now().Add(timeout))
+	defer r.conn.SetReadDeadline(time.Time{})
+	v, err := r.readValueErr()
+	if err != nil {
+		return "", "", false
+	}
+	channel, payload = parseMessagePush(r.t, v)
+	return channel, payload, true
+}
+
+func parseMessagePush(t *testing.T, v interface{}) (channel, payload string) {
+	t.Helper()
+	elems, ok := v.([]interface{})
+	if !ok || len(elems) != 3 {
+		t.Fatalf("expected a 3-element message push, got %#v", v)
+	}
+	channel, _ = elems[1].(string)
+	payload, _ = elems[2].(string)
+	return channel, payload
+}
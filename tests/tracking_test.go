@@ -0,0 +1,158 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// newRESP3Client connects to the Redis frontend and negotiates RESP3 via
+// HELLO 3, which redigo can't do - hence go-redis for these tests.
+func newRESP3Client(addrs serverAddrs) *redis.Client {
+	return redis.NewClient(&redis.Options{
+		Addr:       addrs.Resp,
+		Protocol:   3,
+		PoolSize:   1,
+		MaxRetries: -1,
+	})
+}
+
+// TestClientTrackingInvalidatesOnWrite checks the default (OPTOUT-style)
+// tracking mode: once a client GETs a key with CLIENT TRACKING ON, a
+// write to that key from another connection pushes an invalidation
+// message on the __redis__:invalidate channel of the tracking
+// connection itself - no REDIRECT or SUBSCRIBE involved, since a RESP3
+// connection can receive unsolicited pushes interleaved with its normal
+// command replies. This needs a raw connection rather than go-redis,
+// since go-redis's regular Client has no way to surface an unsolicited
+// push arriving on its command connection.
+func TestClientTrackingInvalidatesOnWrite(t *testing.T) {
+	addrs := startServer(t)
+	ctx := context.Background()
+
+	tracked := dialRawRESP(t, addrs.Resp)
+	defer tracked.Close()
+	tracked.hello3()
+
+	tracked.do("CLIENT", "TRACKING", "ON")
+	tracked.do("SET", "tracked-key", "v1")
+	tracked.do("GET", "tracked-key")
+
+	writer := redis.NewClient(&redis.Options{Addr: addrs.Resp})
+	defer writer.Close()
+	if err := writer.Set(ctx, "tracked-key", "v2", 0).Err(); err != nil {
+		t.Fatalf("SET from other connection: %v", err)
+	}
+
+	channel, payload := tracked.readPush()
+	if channel != "__redis__:invalidate" || payload != "tracked-key" {
+		t.Fatalf("unexpected invalidation push: channel=%q payload=%q", channel, payload)
+	}
+}
+
+// TestClientTrackingBroadcastByPrefix checks BCAST mode: a client that
+// registers interest in a key prefix is notified of any write under that
+// prefix without having read the key first. As with the default mode
+// above, delivery lands on the tracking connection itself.
+func TestClientTrackingBroadcastByPrefix(t *testing.T) {
+	addrs := startServer(t)
+	ctx := context.Background()
+
+	tracked := dialRawRESP(t, addrs.Resp)
+	defer tracked.Close()
+	tracked.hello3()
+	tracked.do("CLIENT", "TRACKING", "ON", "BCAST", "PREFIX", "session:")
+
+	writer := redis.NewClient(&redis.Options{Addr: addrs.Resp})
+	defer writer.Close()
+	if err := writer.Set(ctx, "session:42", "data", 0).Err(); err != nil {
+		t.Fatalf("SET under tracked prefix: %v", err)
+	}
+
+	channel, payload := tracked.readPush()
+	if channel != "__redis__:invalidate" || payload != "session:42" {
+		t.Fatalf("unexpected broadcast invalidation push: channel=%q payload=%q", channel, payload)
+	}
+}
+
+// TestClientTrackingInvalidationIsNotBroadcastToOtherTrackers checks
+// that with two tracking clients connected at once, a write to a key
+// only one of them tracked notifies only that one - not both, which is
+// what a broker-wide publish on invalidateChannel would have done.
+func TestClientTrackingInvalidationIsNotBroadcastToOtherTrackers(t *testing.T) {
+	addrs := startServer(t)
+	ctx := context.Background()
+
+	interested := dialRawRESP(t, addrs.Resp)
+	defer interested.Close()
+	interested.hello3()
+	interested.do("CLIENT", "TRACKING", "ON")
+
+	bystander := dialRawRESP(t, addrs.Resp)
+	defer bystander.Close()
+	bystander.hello3()
+	bystander.do("CLIENT", "TRACKING", "ON")
+
+	writer := redis.NewClient(&redis.Options{Addr: addrs.Resp})
+	defer writer.Close()
+
+	if err := writer.Set(ctx, "only-interested", "v1", 0).Err(); err != nil {
+		t.Fatalf("priming SET: %v", err)
+	}
+	interested.do("GET", "only-interested")
+	// The bystander never reads this key, so it must never be notified
+	// about it - even though it's also tracking-enabled.
+	bystander.do("GET", "some-other-key")
+
+	if err := writer.Set(ctx, "only-interested", "v2", 0).Err(); err != nil {
+		t.Fatalf("SET from other connection: %v", err)
+	}
+
+	channel, payload := interested.readPush()
+	if channel != "__redis__:invalidate" || payload != "only-interested" {
+		t.Fatalf("unexpected invalidation push: channel=%q payload=%q", channel, payload)
+	}
+
+	if _, payload, ok := bystander.tryReadPush(300 * time.Millisecond); ok {
+		t.Fatalf("bystander should not have been invalidated, got %q", payload)
+	}
+}
+
+// TestClientTrackingRedirect checks the REDIRECT form, where tracking
+// notifications for one connection are delivered on a different
+// connection's invalidation channel, identified by its CLIENT ID.
+func TestClientTrackingRedirect(t *testing.T) {
+	addrs := startServer(t)
+	ctx := context.Background()
+
+	listener := dialRawRESP(t, addrs.Resp)
+	defer listener.Close()
+	listener.hello3()
+	listenerID := listener.clientID()
+
+	reader := newRESP3Client(addrs)
+	defer reader.Close()
+	if err := reader.Do(ctx, "CLIENT", "TRACKING", "ON", "REDIRECT", fmt.Sprint(listenerID)).Err(); err != nil {
+		t.Fatalf("CLIENT TRACKING ON REDIRECT: %v", err)
+	}
+	if err := reader.Set(ctx, "redirected-key", "v1", 0).Err(); err != nil {
+		t.Fatalf("priming SET: %v", err)
+	}
+	if err := reader.Get(ctx, "redirected-key").Err(); err != nil {
+		t.Fatalf("GET to arm tracking: %v", err)
+	}
+
+	writer := redis.NewClient(&redis.Options{Addr: addrs.Resp})
+	defer writer.Close()
+	if err := writer.Set(ctx, "redirected-key", "v2", 0).Err(); err != nil {
+		t.Fatalf("SET from other connection: %v", err)
+	}
+
+	channel, payload := listener.readPush()
+	if channel != "__redis__:invalidate" || payload != "redirected-key" {
+		t.Fatalf("unexpected redirected invalidation push: channel=%q payload=%q", channel, payload)
+	}
+}